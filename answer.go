@@ -0,0 +1,204 @@
+package monebot
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+func NewTextAnswer(text string) Answer {
+	text = RemoveUnsupportedVerbs(text)
+	return Answer{Text: text, NumParams: CountVerbs(text)}
+}
+
+func NewStickerAnswer(sticker string) Answer {
+	return Answer{Sticker: sticker}
+}
+
+// Choices returns the answer itself followed by its alternatives, each
+// without alternatives of its own
+func (a Answer) Choices() []Answer {
+	first := a
+	first.Alternatives = nil
+	choices := []Answer{first}
+	for _, alt := range a.Alternatives {
+		alt.NumParams = a.NumParams
+		alt.Alternatives = nil
+		choices = append(choices, alt)
+	}
+	return choices
+}
+
+// AddAlternative returns the answer with alt as one more choice
+func (a Answer) AddAlternative(alt Answer) Answer {
+	alt.NumParams = a.NumParams
+	alt.Alternatives = nil
+	a.Alternatives = append(append([]Answer(nil), a.Alternatives...), alt)
+	return a
+}
+
+// Texts returns the answer with only its text choices, which can replace
+// each other in a sent message
+func (a Answer) Texts() Answer {
+	var texts Answer
+	for _, choice := range a.Choices() {
+		switch {
+		case choice.Text == "":
+		case texts.Text == "":
+			texts = choice
+		default:
+			texts = texts.AddAlternative(choice)
+		}
+	}
+	texts.NumParams = a.NumParams
+	return texts
+}
+
+// Index returns the index of the choice with the text, or -1
+func (a Answer) Index(text string) int {
+	for i, choice := range a.Choices() {
+		if choice.Text == text {
+			return i
+		}
+	}
+	return -1
+}
+
+// Pick chooses one of the choices at random according to their weights,
+// avoiding the choice at index last unless it is the only one. It returns
+// the choice and its index
+func (a Answer) Pick(r *rand.Rand, last int) (Answer, int) {
+	choices := a.Choices()
+	if len(choices) == 1 {
+		return choices[0], 0
+	}
+
+	weight := func(i int) int {
+		if i == last {
+			return 0
+		}
+		if choices[i].Weight <= 0 {
+			return 1
+		}
+		return choices[i].Weight
+	}
+
+	var total int
+	for i := range choices {
+		total += weight(i)
+	}
+
+	n := r.Intn(total)
+	for i := range choices {
+		if n -= weight(i); n < 0 {
+			return choices[i], i
+		}
+	}
+	return choices[0], 0
+}
+
+// FormatParams replaces the verbs of the text with the params
+func FormatParams(text string, params []string) string {
+	if len(params) == 0 {
+		return text
+	}
+
+	p := make([]interface{}, 0, len(params))
+	for _, param := range params {
+		p = append(p, param)
+	}
+	return fmt.Sprintf(text, p...)
+}
+
+// CountVerbs returns the number of string verbs (%s, %[1]s...)
+// taking into consideration indexed and non-indexed verbs. Placeholders,
+// which take parameters as {1}, {2}..., are skipped
+func CountVerbs(s string) int {
+	var outside string
+	for i, part := range splitPlaceholders(s) {
+		if i%2 == 0 {
+			outside += part
+		}
+	}
+
+	matches := regexp.MustCompile("%(?:\\[(\\d+)\\])?s").FindAllStringSubmatch(outside, -1)
+	var numNotIndexed, maxIndex int
+	for _, submatches := range matches {
+		if indexStr := submatches[len(submatches)-1]; indexStr == "" {
+			numNotIndexed++
+		} else {
+			index, err := strconv.Atoi(indexStr)
+			if err != nil {
+				numNotIndexed++
+				continue
+			}
+			if index > maxIndex {
+				maxIndex = index
+			}
+		}
+	}
+	if maxIndex > numNotIndexed {
+		return maxIndex
+	}
+	return numNotIndexed
+}
+
+// RemoveUnsupportedVerbs returns a cleaner version of a format string,
+// trying to replace most unsupported Printf verbs (%d, %[1]v, %#v etc.).
+// Placeholders are kept as is, e.g. the modulo in {calc:10%3}
+func RemoveUnsupportedVerbs(s string) string {
+	parts := splitPlaceholders(s)
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = removeUnsupportedVerbs(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+// splitPlaceholders splits s around its placeholders, alternating the text
+// outside them and the placeholders themselves, braces included
+func splitPlaceholders(s string) []string {
+	var parts []string
+	for offset := 0; ; {
+		start := strings.IndexByte(s[offset:], '{')
+		if start == -1 {
+			break
+		}
+		start += offset
+		end := matchingBrace(s, start)
+		if end == -1 {
+			break
+		}
+		offset = end + 1
+
+		// Unknown prefixes aren't expanded, so their text is formatted
+		inner := s[start+1 : end]
+		colon := strings.IndexByte(inner, ':')
+		if colon == -1 || Placeholders[inner[:colon]] == nil {
+			continue
+		}
+		parts = append(parts, s[:start], s[start:end+1])
+		s, offset = s[end+1:], 0
+	}
+	return append(parts, s)
+}
+
+func removeUnsupportedVerbs(s string) string {
+	return regexp.MustCompile("%#?(?:\\[\\d+\\])?[^%s\\s\\[]").ReplaceAllStringFunc(s,
+		func(match string) string {
+			start := strings.IndexRune(match, '[')
+			end := strings.IndexRune(match, ']')
+
+			// Handle indexed verb
+			if start < end {
+				index, err := strconv.Atoi(match[start+1 : end])
+				if err != nil {
+					return "%s"
+				}
+				return fmt.Sprintf("%%[%d]s", index)
+			}
+
+			return "%s"
+		})
+}
@@ -0,0 +1,87 @@
+package monebot
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCountVerbs(t *testing.T) {
+	if n := CountVerbs("%s"); n != 1 {
+		t.Error("Expected 1, got", n)
+	}
+
+	if n := CountVerbs("%[1]s"); n != 1 {
+		t.Error("Expected 1, got", n)
+	}
+
+	if n := CountVerbs("%[2]s"); n != 2 {
+		t.Error("Expected 2, got", n)
+	}
+
+	if n := CountVerbs("{calc:10%s} %s"); n != 1 {
+		t.Error("Expected 1, got", n)
+	}
+}
+
+func TestRemoveUnsupportedVerbs(t *testing.T) {
+	if s := RemoveUnsupportedVerbs("%d"); !(s == "%s") {
+		t.Errorf("Expected %%s, got %s", s)
+	}
+
+	if s := RemoveUnsupportedVerbs("%d {calc:10%3} {unknown:%d}"); s != "%s {calc:10%3} {unknown:%s}" {
+		t.Errorf("Expected placeholders kept, got %s", s)
+	}
+}
+
+func TestPickAvoidsLast(t *testing.T) {
+	a := NewTextAnswer("hi").AddAlternative(NewStickerAnswer("sticker"))
+	r := rand.New(rand.NewSource(1))
+
+	last := 0
+	for i := 0; i < 10; i++ {
+		choice, picked := a.Pick(r, last)
+		if picked == last {
+			t.Fatal("Expected a different choice than", last)
+		}
+		if (picked == 0) != (choice.Text == "hi") {
+			t.Errorf("Expected choice %d, got %+v", picked, choice)
+		}
+		last = picked
+	}
+}
+
+func TestPickWeights(t *testing.T) {
+	heavy := NewTextAnswer("heavy")
+	heavy.Weight = 9
+	a := NewTextAnswer("light").AddAlternative(heavy)
+	r := rand.New(rand.NewSource(1))
+
+	var counts [2]int
+	for i := 0; i < 1000; i++ {
+		_, picked := a.Pick(r, -1)
+		counts[picked]++
+	}
+	if counts[1] < 800 {
+		t.Error("Expected about 900 heavy choices, got", counts[1])
+	}
+}
+
+func TestPickSingle(t *testing.T) {
+	if _, picked := NewTextAnswer("hi").Pick(rand.New(rand.NewSource(1)), 0); picked != 0 {
+		t.Error("Expected the only choice, got", picked)
+	}
+}
+
+func TestTexts(t *testing.T) {
+	a := NewStickerAnswer("sticker").
+		AddAlternative(NewTextAnswer("hi")).
+		AddAlternative(NewTextAnswer("hello"))
+
+	texts := a.Texts()
+	if n := len(texts.Choices()); n != 2 {
+		t.Fatal("Expected 2 text choices, got", n)
+	}
+	if i := texts.Index("hello"); i != 1 {
+		t.Error("Expected hello at 1, got", i)
+	}
+}
@@ -0,0 +1,336 @@
+package monebot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BackupVersion is the version of the archive format written by Backup
+const BackupVersion = 1
+
+// manifestName is the first entry of a backup archive
+const manifestName = "manifest.json"
+
+// Documents are restored restoreBatch at a time, and are never larger than
+// maxDocumentSize (16MB, as in mongodb)
+const (
+	restoreBatch    = 1000
+	maxDocumentSize = 16 << 20
+)
+
+var ErrNotEmpty = errors.New("Database not empty")
+
+// BackupManifest describes the collections in a backup archive, read
+// between Time and Finished
+type BackupManifest struct {
+	Version     int                `json:"version"`
+	Time        time.Time          `json:"time"`
+	Finished    time.Time          `json:"finished,omitempty"`
+	Collections []BackupCollection `json:"collections"`
+}
+
+// BackupCollection is a collection in a backup archive, with its number of
+// documents and the SHA-256 of their BSON
+type BackupCollection struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+	SHA256    string `json:"sha256"`
+}
+
+// Backup writes all collections to w as a gzipped tar archive, with a
+// manifest followed by one entry of concatenated BSON documents per
+// collection. The documents are spooled to a temporary file first, as the
+// manifest and the size of each entry are only known once all are read.
+//
+// It is not a point-in-time snapshot: collections are read one after
+// another while the bot keeps writing, so changes made between the manifest's
+// Time and Finished may be in some collections and not in others
+func (db *Database) Backup(ctx context.Context, w io.Writer) (BackupManifest, error) {
+	m := BackupManifest{Version: BackupVersion, Time: time.Now()}
+
+	spool, err := ioutil.TempFile("", "monebot-backup-")
+	if err != nil {
+		return m, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	var sizes []int64
+	err = db.with(ctx, func(s *mgo.Session) error {
+		names, err := collectionNames(s)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			hash := sha256.New()
+			out := io.MultiWriter(spool, hash)
+			var raw bson.Raw
+			var size int64
+			n := 0
+
+			// Walking the _id index, a document updated meanwhile is never
+			// read twice
+			iter := s.DB("").C(name).Find(nil).Sort("_id").Iter()
+			for iter.Next(&raw) {
+				if _, err := out.Write(raw.Data); err != nil {
+					iter.Close()
+					return err
+				}
+				size += int64(len(raw.Data))
+				n++
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+
+			m.Collections = append(m.Collections,
+				BackupCollection{Name: name, Documents: n, SHA256: hex.EncodeToString(hash.Sum(nil))})
+			sizes = append(sizes, size)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	m.Finished = time.Now()
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+
+	if err := writeEntry(archive, manifestName, bytes.NewReader(manifest), int64(len(manifest)), m.Time); err != nil {
+		return m, err
+	}
+	var offset int64
+	for i, c := range m.Collections {
+		content := io.NewSectionReader(spool, offset, sizes[i])
+		if err := writeEntry(archive, c.Name+".bson", content, sizes[i], m.Time); err != nil {
+			return m, err
+		}
+		offset += sizes[i]
+	}
+
+	if err := archive.Close(); err != nil {
+		return m, err
+	}
+	return m, gz.Close()
+}
+
+// VerifyBackup reads the whole archive, checking every collection against
+// the manifest
+func VerifyBackup(r io.Reader) (BackupManifest, error) {
+	return readBackup(r, nil)
+}
+
+// Restore verifies the archive and then inserts all its documents. The
+// database must be empty, as restoring merges nothing
+func (db *Database) Restore(ctx context.Context, r io.ReadSeeker) (BackupManifest, error) {
+	m, err := VerifyBackup(r)
+	if err != nil {
+		return m, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return m, err
+	}
+
+	err = db.with(ctx, func(s *mgo.Session) error {
+		names, err := collectionNames(s)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			n, err := s.DB("").C(name).Count()
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				return ErrNotEmpty
+			}
+		}
+
+		_, err = readBackup(r, func(name string, docs []bson.Raw) error {
+			batch := make([]interface{}, 0, len(docs))
+			for _, doc := range docs {
+				batch = append(batch, doc)
+			}
+			return s.DB("").C(name).Insert(batch...)
+		})
+		return err
+	})
+
+	return m, err
+}
+
+// collectionNames returns the names of all collections but the system ones
+func collectionNames(s *mgo.Session) ([]string, error) {
+	all, err := s.DB("").CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range all {
+		if !strings.HasPrefix(name, "system.") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func writeEntry(archive *tar.Writer, name string, content io.Reader, size int64, t time.Time) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    size,
+		ModTime: t,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(archive, content)
+	return err
+}
+
+// readBackup reads the archive, verifying each collection and passing its
+// documents to restore, if not nil, in batches of up to restoreBatch. The
+// documents are passed before the collection's checksum is checked, so
+// the archive must have been verified first. Entries not in the manifest,
+// and collections restore couldn't write to, make the archive invalid
+func readBackup(r io.Reader, restore func(name string, docs []bson.Raw) error) (BackupManifest, error) {
+	var m BackupManifest
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return m, err
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	header, err := archive.Next()
+	if err != nil {
+		return m, err
+	}
+	if header.Name != manifestName {
+		return m, fmt.Errorf("expected %s, found %s", manifestName, header.Name)
+	}
+	if err := json.NewDecoder(archive).Decode(&m); err != nil {
+		return m, err
+	}
+	if m.Version < 1 || m.Version > BackupVersion {
+		return m, fmt.Errorf("unsupported backup version %d", m.Version)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range m.Collections {
+		if c.Name == "" || strings.HasPrefix(c.Name, "system.") || strings.ContainsAny(c.Name, "$/\x00") {
+			return m, fmt.Errorf("invalid collection name '%s'", c.Name)
+		}
+		if seen[c.Name] {
+			return m, fmt.Errorf("collection %s is listed twice", c.Name)
+		}
+		seen[c.Name] = true
+	}
+
+	for _, c := range m.Collections {
+		header, err := archive.Next()
+		if err != nil {
+			return m, fmt.Errorf("collection %s: %s", c.Name, err)
+		}
+		if header.Name != c.Name+".bson" {
+			return m, fmt.Errorf("expected %s.bson, found %s", c.Name, header.Name)
+		}
+
+		hash := sha256.New()
+		content := io.TeeReader(archive, hash)
+		var batch []bson.Raw
+		n := 0
+		for {
+			doc, err := readDocument(content)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return m, fmt.Errorf("collection %s: %s", c.Name, err)
+			}
+			n++
+
+			if restore == nil {
+				continue
+			}
+			if batch = append(batch, doc); len(batch) == restoreBatch {
+				if err := restore(c.Name, batch); err != nil {
+					return m, fmt.Errorf("collection %s: %s", c.Name, err)
+				}
+				batch = nil
+			}
+		}
+		if len(batch) > 0 {
+			if err := restore(c.Name, batch); err != nil {
+				return m, fmt.Errorf("collection %s: %s", c.Name, err)
+			}
+		}
+
+		if hex.EncodeToString(hash.Sum(nil)) != c.SHA256 {
+			return m, fmt.Errorf("collection %s: checksum mismatch", c.Name)
+		}
+		if n != c.Documents {
+			return m, fmt.Errorf("collection %s: expected %d documents, found %d",
+				c.Name, c.Documents, n)
+		}
+	}
+
+	if header, err := archive.Next(); err == nil {
+		return m, fmt.Errorf("unexpected entry %s after the collections", header.Name)
+	} else if err != io.EOF {
+		return m, err
+	}
+	return m, nil
+}
+
+// readDocument reads the next of concatenated BSON documents, each starting
+// with its length as a little endian int32, returning io.EOF after the last
+func readDocument(r io.Reader) (bson.Raw, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err == io.ErrUnexpectedEOF {
+		return bson.Raw{}, errors.New("truncated document")
+	} else if err != nil {
+		return bson.Raw{}, err
+	}
+
+	n := int(binary.LittleEndian.Uint32(size[:]))
+	if n < 5 || n > maxDocumentSize {
+		return bson.Raw{}, fmt.Errorf("invalid document size %d", n)
+	}
+
+	data := make([]byte, n)
+	copy(data, size[:])
+	if _, err := io.ReadFull(r, data[4:]); err != nil {
+		return bson.Raw{}, errors.New("truncated document")
+	}
+	return bson.Raw{Kind: 0x03, Data: data}, nil
+}
@@ -0,0 +1,77 @@
+package monebot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// archive returns a backup archive with the commands collection holding docs,
+// and a manifest with the given checksum, or the right one if empty
+func archive(t *testing.T, checksum string, docs ...interface{}) *bytes.Reader {
+	var content []byte
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, data...)
+	}
+
+	if checksum == "" {
+		sum := sha256.Sum256(content)
+		checksum = hex.EncodeToString(sum[:])
+	}
+
+	manifest, _ := json.Marshal(BackupManifest{Version: BackupVersion, Collections: []BackupCollection{
+		{Name: "commands", Documents: len(docs), SHA256: checksum}}})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	writeEntry(w, manifestName, bytes.NewReader(manifest), int64(len(manifest)), time.Now())
+	writeEntry(w, "commands.bson", bytes.NewReader(content), int64(len(content)), time.Now())
+	w.Close()
+	gz.Close()
+
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestVerifyBackup(t *testing.T) {
+	m, err := VerifyBackup(archive(t, "", bson.M{"name": "hug"}, bson.M{"name": "slap"}))
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if n := m.Collections[0].Documents; n != 2 {
+		t.Error("Expected 2 documents, got", n)
+	}
+}
+
+func TestVerifyBackupChecksum(t *testing.T) {
+	if _, err := VerifyBackup(archive(t, "bad", bson.M{"name": "hug"})); err == nil {
+		t.Error("Expected checksum mismatch")
+	}
+}
+
+func TestVerifyBackupTrailingEntry(t *testing.T) {
+	manifest, _ := json.Marshal(BackupManifest{Version: BackupVersion})
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	writeEntry(w, manifestName, bytes.NewReader(manifest), int64(len(manifest)), time.Now())
+	writeEntry(w, "commands.bson", bytes.NewReader(nil), 0, time.Now())
+	w.Close()
+	gz.Close()
+
+	if _, err := VerifyBackup(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Expected an entry not in the manifest to be rejected")
+	}
+}
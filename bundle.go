@@ -0,0 +1,333 @@
+package monebot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/victormoneratto/monebot/util"
+	"gopkg.in/yaml.v2"
+)
+
+// BundleVersion is the version of the bundle format written by ExportPack.
+// Bundles of older versions can still be imported
+const BundleVersion = 1
+
+// Bundle holds a pack's commands, to be moved between bots or kept in files
+type Bundle struct {
+	Version    int             `json:"version"`
+	Pack       string          `json:"pack"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	Commands   []BundleCommand `json:"commands"`
+}
+
+// BundleCommand is a command or alias in a bundle, the history is optional
+type BundleCommand struct {
+	Name    string          `json:"name"`
+	Answer  Answer          `json:"answer"`
+	Alias   *CommandRef     `json:"alias,omitempty"`
+	Rules   []Rule          `json:"rules,omitempty"`
+	History *CommandHistory `json:"history,omitempty"`
+}
+
+// CommandHistory is who last changed a command, when, and how many times
+type CommandHistory struct {
+	Creator    string    `json:"creator,omitempty"`
+	Time       time.Time `json:"time"`
+	NumChanged int       `json:"numChanged,omitempty"`
+}
+
+// Formats of bundle files, YAML bundles use the same fields as JSON ones
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Policies for importing a command that already exists in the pack
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+)
+
+// ImportResult tells what happened to each command of an imported bundle
+type ImportResult struct {
+	Pack     string            `json:"pack"`
+	Imported []string          `json:"imported"`
+	Skipped  []string          `json:"skipped"`
+	Renamed  map[string]string `json:"renamed"`
+}
+
+// ExportPack returns a bundle with all commands of the pack, with their
+// history if asked to
+func ExportPack(ctx context.Context, s Store, pack string, history bool) (Bundle, error) {
+	b := Bundle{Version: BundleVersion, Pack: pack, ExportedAt: time.Now(), Commands: []BundleCommand{}}
+
+	commands, err := s.ListCommands(ctx, pack)
+	if err != nil {
+		return b, err
+	}
+
+	for _, c := range commands {
+		bc := BundleCommand{Name: c.Name, Answer: c.Answer, Alias: c.Alias, Rules: c.Rules}
+		if history {
+			bc.History = &CommandHistory{Creator: c.Creator, Time: c.Time, NumChanged: c.NumChanged}
+		}
+		b.Commands = append(b.Commands, bc)
+	}
+
+	return b, nil
+}
+
+// WriteBundle encodes the bundle in the format
+func WriteBundle(w io.Writer, b Bundle, format string) error {
+	content, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatJSON:
+		content = append(content, '\n')
+	case FormatYAML:
+		// Through the JSON fields, so both formats have the same ones
+		var v interface{}
+		if err := json.Unmarshal(content, &v); err != nil {
+			return err
+		}
+		if content, err = yaml.Marshal(v); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown bundle format '%s'", format)
+	}
+
+	_, err = w.Write(content)
+	return err
+}
+
+// ReadBundle decodes and validates a bundle, either JSON or YAML
+func ReadBundle(r io.Reader) (Bundle, error) {
+	var b Bundle
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return b, err
+	}
+
+	// JSON bundles always hold an object, YAML ones rarely start with braces
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		var v interface{}
+		if err := yaml.Unmarshal(content, &v); err != nil {
+			return b, err
+		}
+		if content, err = json.Marshal(jsonValue(v)); err != nil {
+			return b, err
+		}
+	}
+
+	if err := json.Unmarshal(content, &b); err != nil {
+		return b, err
+	}
+
+	if b.Version < 1 || b.Version > BundleVersion {
+		return b, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	for i, c := range b.Commands {
+		if c.Name == "" {
+			return b, fmt.Errorf("command %d has no name", i)
+		}
+		if c.Alias != nil {
+			if c.Alias.Name == "" {
+				return b, fmt.Errorf("alias %s has no target", c.Name)
+			}
+			b.Commands[i].Answer = Answer{NumParams: AnyParams}
+			continue
+		}
+
+		ans, err := checkAnswer(c.Name, c.Answer)
+		if err != nil {
+			return b, err
+		}
+		b.Commands[i].Answer = ans
+
+		for j, r := range c.Rules {
+			if r.Answer, err = checkAnswer(c.Name, r.Answer); err != nil {
+				return b, err
+			}
+			if r.Answer.NumParams != ans.NumParams {
+				return b, fmt.Errorf("rules of command %s have different parameters", c.Name)
+			}
+			b.Commands[i].Rules[j] = r
+		}
+	}
+
+	return b, nil
+}
+
+// jsonValue returns the decoded YAML value with its maps keyed by strings,
+// as encoding/json requires
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
+
+// checkAnswer returns the answer of the command with the number of
+// parameters of its texts, which isn't trusted, or an error if the answer
+// is invalid
+func checkAnswer(name string, a Answer) (Answer, error) {
+	var ans Answer
+	for j, choice := range a.Choices() {
+		if choice.Text == "" && choice.Sticker == "" {
+			return ans, fmt.Errorf("command %s has no answer", name)
+		}
+
+		checked := NewStickerAnswer(choice.Sticker)
+		if choice.Text != "" {
+			checked = NewTextAnswer(choice.Text)
+			checked.Parse = choice.Parse
+		}
+		checked.Weight = choice.Weight
+
+		if j == 0 {
+			ans = checked
+		} else if checked.NumParams != ans.NumParams {
+			return ans, fmt.Errorf("alternatives of command %s have different parameters", name)
+		} else {
+			ans = ans.AddAlternative(checked)
+		}
+	}
+	return ans, nil
+}
+
+// ImportBundle saves the commands of the bundle into pack, deciding what to
+// do with the ones that already exist according to policy
+func ImportBundle(ctx context.Context, s Store, b Bundle, pack, policy string) (ImportResult, error) {
+	result := ImportResult{Pack: pack, Imported: []string{}, Skipped: []string{}, Renamed: map[string]string{}}
+
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+	default:
+		return result, fmt.Errorf("unknown conflict policy '%s'", policy)
+	}
+
+	existing, err := s.ListCommands(ctx, pack)
+	if err != nil {
+		return result, err
+	}
+
+	// The commands of each normalized name, by number of parameters, with
+	// their names as saved
+	taken := make(map[string]map[int]string)
+	take := func(name, saved string, numParams int) {
+		if taken[name] == nil {
+			taken[name] = make(map[int]string)
+		}
+		taken[name][numParams] = saved
+	}
+	for _, c := range existing {
+		take(util.NormalizeName(c.Name), c.Name, c.Answer.NumParams)
+	}
+
+	// An alias takes any number of parameters, so it conflicts with every
+	// command of its name, as AliasCommand does
+	conflicts := func(name string, numParams int) []int {
+		var found []int
+		for n := range taken[name] {
+			if n == numParams || n == AnyParams || numParams == AnyParams {
+				found = append(found, n)
+			}
+		}
+		return found
+	}
+
+	// Names are decided first, so that aliases follow renamed targets
+	type entry struct {
+		bc     BundleCommand
+		name   string
+		remove map[int]string
+	}
+	var entries []entry
+	renamed := make(map[string]string)
+
+	// Names as UpsertCommand saves them, so that conflicts are found
+	for _, bc := range b.Commands {
+		base := util.NormalizeName(bc.Name)
+		e := entry{bc: bc, name: base}
+		if found := conflicts(base, bc.Answer.NumParams); len(found) > 0 {
+			switch policy {
+			case ConflictSkip:
+				result.Skipped = append(result.Skipped, bc.Name)
+				continue
+			case ConflictOverwrite:
+				// The one with the same parameters is replaced when saved
+				e.remove = make(map[int]string)
+				for _, n := range found {
+					if n != bc.Answer.NumParams {
+						e.remove[n] = taken[base][n]
+						delete(taken[base], n)
+					}
+				}
+			case ConflictRename:
+				for i := 2; len(conflicts(e.name, bc.Answer.NumParams)) > 0; i++ {
+					e.name = fmt.Sprintf("%s_%d", base, i)
+				}
+				result.Renamed[bc.Name] = e.name
+				renamed[base] = e.name
+			}
+		}
+		take(e.name, e.name, bc.Answer.NumParams)
+		entries = append(entries, e)
+	}
+
+	for _, e := range entries {
+		bc, name := e.bc, e.name
+		for n, saved := range e.remove {
+			if err := s.RemoveCommand(ctx, pack, saved, n); err != nil && err != ErrNotFound {
+				return result, err
+			}
+		}
+
+		c := Command{Pack: pack, Name: name, Answer: bc.Answer, Rules: bc.Rules, Time: time.Now()}
+		if bc.Alias != nil {
+			// Aliases within the bundle follow it into the pack, and to the
+			// new names of the commands renamed
+			target := *bc.Alias
+			if target.Pack == b.Pack {
+				target.Pack = pack
+				if n, ok := renamed[util.NormalizeName(target.Name)]; ok {
+					target.Name = n
+				}
+			}
+			c.Alias = &target
+		}
+		if bc.History != nil {
+			c.Creator, c.NumChanged = bc.History.Creator, bc.History.NumChanged
+			if !bc.History.Time.IsZero() {
+				c.Time = bc.History.Time
+			}
+		}
+
+		if err := s.UpsertCommand(ctx, c); err != nil {
+			return result, err
+		}
+		result.Imported = append(result.Imported, name)
+	}
+
+	return result, nil
+}
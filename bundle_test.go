@@ -0,0 +1,168 @@
+package monebot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/victormoneratto/monebot/util"
+)
+
+// packStore holds the commands of a pack, normalizing names on save as
+// Database does
+type packStore struct {
+	Store
+	commands []Command
+}
+
+func (s *packStore) ListCommands(ctx context.Context, packs ...string) ([]Command, error) {
+	return s.commands, nil
+}
+
+func (s *packStore) UpsertCommand(ctx context.Context, c Command) error {
+	c.Name = util.NormalizeName(c.Name)
+	s.RemoveCommand(ctx, c.Pack, c.Name, c.Answer.NumParams)
+	s.commands = append(s.commands, c)
+	return nil
+}
+
+func (s *packStore) RemoveCommand(ctx context.Context, pack, name string, numParams int) error {
+	for i, c := range s.commands {
+		if c.Pack == pack && c.Name == name && c.Answer.NumParams == numParams {
+			s.commands = append(s.commands[:i], s.commands[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func TestReadBundle(t *testing.T) {
+	b, err := ReadBundle(strings.NewReader(`{"version": 1, "pack": "memes",
+		"commands": [{"name": "hug", "answer": {"text": "*hugs %d*", "numParams": 5}}]}`))
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	if ans := b.Commands[0].Answer; ans.Text != "*hugs %s*" || ans.NumParams != 1 {
+		t.Errorf("Expected '*hugs %%s*' with 1 parameter, got '%s' with %d", ans.Text, ans.NumParams)
+	}
+}
+
+func TestReadBundleAlias(t *testing.T) {
+	b, err := ReadBundle(strings.NewReader(`{"version": 1, "pack": "memes",
+		"commands": [{"name": "abraco", "answer": {"text": "hi"}, "alias": {"pack": "memes", "name": "hug"}}]}`))
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	if ans := b.Commands[0].Answer; ans.Text != "" || ans.NumParams != AnyParams {
+		t.Errorf("Expected no answer with any parameters, got '%s' with %d", ans.Text, ans.NumParams)
+	}
+}
+
+func TestBundleYAML(t *testing.T) {
+	b := Bundle{Version: BundleVersion, Pack: "memes", Commands: []BundleCommand{
+		{Name: "hug", Answer: NewTextAnswer("*hugs %s*")},
+		{Name: "abraco", Alias: &CommandRef{Pack: "memes", Name: "hug"}},
+	}}
+
+	var out bytes.Buffer
+	if err := WriteBundle(&out, b, FormatYAML); err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if strings.HasPrefix(out.String(), "{") {
+		t.Fatal("Expected YAML, got", out.String())
+	}
+
+	read, err := ReadBundle(&out)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if len(read.Commands) != 2 || read.Commands[0].Answer.Text != "*hugs %s*" ||
+		read.Commands[0].Answer.NumParams != 1 || read.Commands[1].Alias == nil {
+		t.Errorf("Expected the bundle back, got %+v", read)
+	}
+}
+
+func TestReadBundleInvalid(t *testing.T) {
+	for _, bundle := range []string{
+		`{"version": 2, "pack": "memes", "commands": []}`,
+		`{"version": 1, "pack": "memes", "commands": [{"answer": {"text": "hi"}}]}`,
+		`{"version": 1, "pack": "memes", "commands": [{"name": "hug", "answer": {}}]}`,
+		`{"version": 1, "pack": "memes", "commands": [{"name": "hug", "alias": {"pack": "memes"}}]}`,
+		`{"version": 1, "pack": "memes", "commands": [{"name": "hug", "answer": {"text": "hi %s",
+			"alternatives": [{"text": "hi"}]}}]}`,
+	} {
+		if _, err := ReadBundle(strings.NewReader(bundle)); err == nil {
+			t.Error("Expected error reading", bundle)
+		}
+	}
+}
+
+func TestImportBundleNormalizes(t *testing.T) {
+	b := Bundle{Version: BundleVersion, Pack: "memes", Commands: []BundleCommand{
+		{Name: "Hug", Answer: NewTextAnswer("*hugs*")}}}
+
+	s := &packStore{commands: []Command{{Pack: "memes", Name: "hug", Answer: NewTextAnswer("hi")}}}
+	result, err := ImportBundle(context.Background(), s, b, "memes", ConflictSkip)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if len(result.Skipped) != 1 || len(s.commands) != 1 {
+		t.Errorf("Expected Hug to be skipped, got %+v", result)
+	}
+
+	result, err = ImportBundle(context.Background(), s, b, "memes", ConflictRename)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if name := result.Renamed["Hug"]; name != "hug_2" {
+		t.Errorf("Expected Hug renamed to hug_2, got '%s'", name)
+	}
+}
+
+func TestImportBundleAliasShadows(t *testing.T) {
+	b := Bundle{Version: BundleVersion, Pack: "memes", Commands: []BundleCommand{
+		{Name: "hug", Answer: Answer{NumParams: AnyParams}, Alias: &CommandRef{Pack: "memes", Name: "abraco"}}}}
+
+	// A command with other parameters is still shadowed by the alias
+	hug := Command{Pack: "memes", Name: "hug", Answer: NewTextAnswer("*hugs %s*")}
+	s := &packStore{commands: []Command{hug}}
+	result, err := ImportBundle(context.Background(), s, b, "memes", ConflictSkip)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if len(result.Skipped) != 1 || len(s.commands) != 1 {
+		t.Errorf("Expected the alias to be skipped, got %+v", result)
+	}
+
+	result, err = ImportBundle(context.Background(), s, b, "memes", ConflictOverwrite)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if len(s.commands) != 1 || s.commands[0].Alias == nil {
+		t.Errorf("Expected the alias to replace the command, got %+v", s.commands)
+	}
+}
+
+func TestImportBundleRenamesAliasTargets(t *testing.T) {
+	b := Bundle{Version: BundleVersion, Pack: "memes", Commands: []BundleCommand{
+		{Name: "abraco", Answer: Answer{NumParams: AnyParams}, Alias: &CommandRef{Pack: "memes", Name: "hug"}},
+		{Name: "hug", Answer: NewTextAnswer("*hugs*")}}}
+
+	s := &packStore{commands: []Command{{Pack: "chat_1", Name: "hug", Answer: NewTextAnswer("hi")}}}
+	if _, err := ImportBundle(context.Background(), s, b, "chat_1", ConflictRename); err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	for _, c := range s.commands {
+		if c.Name == "abraco" {
+			if want := (CommandRef{Pack: "chat_1", Name: "hug_2"}); *c.Alias != want {
+				t.Errorf("Expected alias to %+v, got %+v", want, *c.Alias)
+			}
+			return
+		}
+	}
+	t.Error("Expected abraco to be imported, got", s.commands)
+}
@@ -0,0 +1,417 @@
+package monebot
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/victormoneratto/monebot/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var ErrReadOnly = errors.New("Database unavailable, read-only mode")
+
+// Cache is a read-through cache of pack, command and settings lookups in front of a
+// Database. Misses are cached as well, and writes through the Cache
+// invalidate the affected entries.
+//
+// While the database is unhealthy the Cache is degraded: lookups are served
+// from cached entries, even expired ones, and writes fail with ErrReadOnly
+type Cache struct {
+	*Database
+
+	packs    *util.LRU
+	commands *util.LRU
+	settings *util.LRU
+
+	hits   uint64
+	misses uint64
+	stale  uint64
+}
+
+// CacheStats counts the lookups answered with and without the database,
+// and those answered with expired entries while degraded
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Stale  uint64
+}
+
+type packKey struct {
+	chat int64
+}
+
+type settingsKey struct {
+	chat int64
+}
+
+type commandKey struct {
+	pack, name string
+	numParams  int
+}
+
+// cached is a lookup result, err is ErrNotFound for a cached miss
+type cached struct {
+	value interface{}
+	err   error
+}
+
+// NewCache returns a cache in front of db holding up to size lookups of
+// each kind for up to ttl
+func NewCache(db *Database, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		Database: db,
+		packs:    util.NewLRU(size, ttl),
+		commands: util.NewLRU(size, ttl),
+		settings: util.NewLRU(size, ttl),
+	}
+}
+
+// Stats returns the hits and misses since the cache was created
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Stale:  atomic.LoadUint64(&c.stale),
+	}
+}
+
+// Degraded reports whether the database is unhealthy, in which case the
+// cache only serves what it already has and rejects writes
+func (c *Cache) Degraded() bool {
+	return !c.Database.Health().Healthy
+}
+
+// lookup returns the cached result for key or the result of find, caching
+// it unless it is an unexpected error or a write invalidated entries while
+// finding it, as it may be from before the write. If the database is
+// unavailable, an expired result is better than none
+func (c *Cache) lookup(lru *util.LRU, key interface{}, zero interface{}, find func() (interface{}, error)) (interface{}, error) {
+	if v, ok := lru.Get(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		r := v.(cached)
+		return r.value, r.err
+	}
+
+	var value interface{}
+	var err error
+	if c.Degraded() {
+		value, err = zero, ErrReadOnly
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+		generation := lru.Generation()
+		value, err = find()
+		if err == nil || err == ErrNotFound || err == ErrAliasCycle {
+			lru.AddSince(generation, key, cached{value, err})
+			return value, err
+		}
+	}
+
+	if v, ok := lru.GetStale(key); ok {
+		atomic.AddUint64(&c.stale, 1)
+		r := v.(cached)
+		return r.value, r.err
+	}
+	return value, err
+}
+
+// FindPack returns the default pack name for the chat
+func (c *Cache) FindPack(ctx context.Context, chat int64) (string, error) {
+	pack, err := c.lookup(c.packs, packKey{chat}, "", func() (interface{}, error) {
+		return c.Database.FindPack(ctx, chat)
+	})
+	return pack.(string), err
+}
+
+// AssignPack makes pack the default pack for the chat
+func (c *Cache) AssignPack(ctx context.Context, chat int64, pack string) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.packs.Remove(packKey{chat})
+	return c.Database.AssignPack(ctx, chat, pack)
+}
+
+// FindCommand returns the one command filtered by the pack, name and numParams
+func (c *Cache) FindCommand(ctx context.Context, pack, name string, numParams int) (Command, error) {
+	name = util.NormalizeName(name)
+	cmd, err := c.lookup(c.commands, commandKey{pack, name, numParams}, Command{}, func() (interface{}, error) {
+		return c.Database.FindCommand(ctx, pack, name, numParams)
+	})
+	return cmd.(Command), err
+}
+
+// UpsertCommand updates or inserts the given command. A command may be the
+// fallback for lookups on any pack, so all cached commands are dropped
+func (c *Cache) UpsertCommand(ctx context.Context, cmd Command) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.commands.Purge()
+	return c.Database.UpsertCommand(ctx, cmd)
+}
+
+// ListCommands returns the commands of the packs, not cached
+func (c *Cache) ListCommands(ctx context.Context, packs ...string) ([]Command, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.ListCommands(ctx, packs...)
+}
+
+// FindSettings returns the settings of the chat
+func (c *Cache) FindSettings(ctx context.Context, chat int64) (Settings, error) {
+	settings, err := c.lookup(c.settings, settingsKey{chat}, DefaultSettings(chat), func() (interface{}, error) {
+		return c.Database.FindSettings(ctx, chat)
+	})
+	return settings.(Settings), err
+}
+
+// UpsertSettings updates or inserts the settings of a chat
+func (c *Cache) UpsertSettings(ctx context.Context, settings Settings) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.settings.Remove(settingsKey{settings.Chat})
+	return c.Database.UpsertSettings(ctx, settings)
+}
+
+// UpsertState updates or inserts the given state
+func (c *Cache) UpsertState(ctx context.Context, s State) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.UpsertState(ctx, s)
+}
+
+// RemoveState removes the state of the user in the chat
+func (c *Cache) RemoveState(ctx context.Context, chat int64, user int) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.RemoveState(ctx, chat, user)
+}
+
+// ExpireStates removes the states last updated before the given time
+func (c *Cache) ExpireStates(ctx context.Context, before time.Time) ([]State, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.ExpireStates(ctx, before)
+}
+
+// FindState returns the state of the user in the chat, as if there was none
+// while degraded
+func (c *Cache) FindState(ctx context.Context, chat int64, user int) (State, error) {
+	if c.Degraded() {
+		return State{}, ErrNotFound
+	}
+	return c.Database.FindState(ctx, chat, user)
+}
+
+// ClaimUpdate leases the update to owner for the given duration
+func (c *Cache) ClaimUpdate(ctx context.Context, updateID int, owner string, lease time.Duration) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.ClaimUpdate(ctx, updateID, owner, lease)
+}
+
+// FinishUpdate marks the update as fully processed
+func (c *Cache) FinishUpdate(ctx context.Context, updateID int) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.FinishUpdate(ctx, updateID)
+}
+
+// ClaimScheduler makes owner the one process running the scheduler
+func (c *Cache) ClaimScheduler(ctx context.Context, owner string, lease time.Duration) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.ClaimScheduler(ctx, owner, lease)
+}
+
+// SaveReroll updates or inserts the reroll of the message
+func (c *Cache) SaveReroll(ctx context.Context, r Reroll) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.SaveReroll(ctx, r)
+}
+
+// ClaimReroll counts one more reroll of the message
+func (c *Cache) ClaimReroll(ctx context.Context, chat int64, message, limit int) (Reroll, error) {
+	if c.Degraded() {
+		return Reroll{}, ErrReadOnly
+	}
+	return c.Database.ClaimReroll(ctx, chat, message, limit)
+}
+
+// SaveRerollText records the text the message was rerolled to
+func (c *Cache) SaveRerollText(ctx context.Context, chat int64, message int, text string) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.SaveRerollText(ctx, chat, message, text)
+}
+
+// FindVariable returns the variable of the scope, not cached
+func (c *Cache) FindVariable(ctx context.Context, scope, name string) (Variable, error) {
+	if c.Degraded() {
+		return Variable{}, ErrReadOnly
+	}
+	return c.Database.FindVariable(ctx, scope, name)
+}
+
+// SetVariable updates or inserts the variable
+func (c *Cache) SetVariable(ctx context.Context, v Variable) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.SetVariable(ctx, v)
+}
+
+// IncrementCounter atomically adds delta to the counter of the scope
+func (c *Cache) IncrementCounter(ctx context.Context, scope, name string, delta int64) (int64, error) {
+	if c.Degraded() {
+		return 0, ErrReadOnly
+	}
+	return c.Database.IncrementCounter(ctx, scope, name, delta)
+}
+
+// RemoveVariable removes the variable of the scope
+func (c *Cache) RemoveVariable(ctx context.Context, scope, name string) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.RemoveVariable(ctx, scope, name)
+}
+
+// AddReminder inserts the reminder, returning it with its ID
+func (c *Cache) AddReminder(ctx context.Context, r Reminder) (Reminder, error) {
+	if c.Degraded() {
+		return r, ErrReadOnly
+	}
+	return c.Database.AddReminder(ctx, r)
+}
+
+// ListReminders returns the reminders of the chat, not cached
+func (c *Cache) ListReminders(ctx context.Context, chat int64) ([]Reminder, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.ListReminders(ctx, chat)
+}
+
+// DueReminders returns the reminders due before the time. While degraded
+// there are none, since delivered reminders couldn't be removed
+func (c *Cache) DueReminders(ctx context.Context, before time.Time) ([]Reminder, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.DueReminders(ctx, before)
+}
+
+// ClaimReminder leases the reminder for a delivery attempt
+func (c *Cache) ClaimReminder(ctx context.Context, id bson.ObjectId, now time.Time, lease time.Duration) (Reminder, error) {
+	if c.Degraded() {
+		return Reminder{}, ErrReadOnly
+	}
+	return c.Database.ClaimReminder(ctx, id, now, lease)
+}
+
+// RemoveReminder removes the reminder of the chat
+func (c *Cache) RemoveReminder(ctx context.Context, chat int64, id bson.ObjectId) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.RemoveReminder(ctx, chat, id)
+}
+
+// AddSchedule inserts the schedule, returning it with its ID
+func (c *Cache) AddSchedule(ctx context.Context, s Schedule) (Schedule, error) {
+	if c.Degraded() {
+		return s, ErrReadOnly
+	}
+	return c.Database.AddSchedule(ctx, s)
+}
+
+// ListSchedules returns the schedules of the chat, not cached
+func (c *Cache) ListSchedules(ctx context.Context, chat int64) ([]Schedule, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.ListSchedules(ctx, chat)
+}
+
+// DueSchedules returns the schedules whose next run is before the time.
+// While degraded there are none, since their runs couldn't be advanced
+func (c *Cache) DueSchedules(ctx context.Context, before time.Time) ([]Schedule, error) {
+	if c.Degraded() {
+		return nil, ErrReadOnly
+	}
+	return c.Database.DueSchedules(ctx, before)
+}
+
+// ClaimSchedule leases the run of the schedule for an attempt to post it
+func (c *Cache) ClaimSchedule(ctx context.Context, id bson.ObjectId, from, now time.Time, lease time.Duration) (Schedule, error) {
+	if c.Degraded() {
+		return Schedule{}, ErrReadOnly
+	}
+	return c.Database.ClaimSchedule(ctx, id, from, now, lease)
+}
+
+// AdvanceSchedule moves the next run of the schedule from one time to another
+func (c *Cache) AdvanceSchedule(ctx context.Context, id bson.ObjectId, from, to time.Time) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.AdvanceSchedule(ctx, id, from, to)
+}
+
+// RemoveSchedule removes the schedule of the chat
+func (c *Cache) RemoveSchedule(ctx context.Context, chat int64, id bson.ObjectId) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.RemoveSchedule(ctx, chat, id)
+}
+
+// SaveOffset stores the ID of the last fully processed update
+func (c *Cache) SaveOffset(ctx context.Context, updateID int) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	return c.Database.SaveOffset(ctx, updateID)
+}
+
+// UnassignPack removes the chat from its default pack, if any
+func (c *Cache) UnassignPack(ctx context.Context, chat int64) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.packs.Remove(packKey{chat})
+	return c.Database.UnassignPack(ctx, chat)
+}
+
+// RemovePack removes the pack along with all its commands
+func (c *Cache) RemovePack(ctx context.Context, name string) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.packs.Purge()
+	defer c.commands.Purge()
+	return c.Database.RemovePack(ctx, name)
+}
+
+// RemoveCommand removes the command from the pack
+func (c *Cache) RemoveCommand(ctx context.Context, pack, name string, numParams int) error {
+	if c.Degraded() {
+		return ErrReadOnly
+	}
+	defer c.commands.Purge()
+	return c.Database.RemoveCommand(ctx, pack, name, numParams)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// CommandFor returns the command in the text without the leading slash and
+// without the "@botname" suffix, and whether it is addressed to the bot:
+// commands suffixed with the name of another bot are not
+func CommandFor(text, botName string) (command string, forBot bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", false
+	}
+
+	command = strings.TrimPrefix(strings.Fields(text)[0], "/")
+	if at := strings.Index(command, "@"); at != -1 {
+		target := command[at+1:]
+		command = command[:at]
+		return command, strings.EqualFold(target, botName)
+	}
+	return command, true
+}
+
+// AddressedToBot reports whether the message is meant for the bot, that is,
+// sent in private, replying to the bot, mentioning it or a command suffixed
+// with its name, as in /hug@monebot
+func AddressedToBot(message *tgbotapi.Message, bot tgbotapi.User) bool {
+	if message.Chat != nil && message.Chat.IsPrivate() {
+		return true
+	}
+
+	if fields := strings.Fields(message.Text); len(fields) > 0 && strings.HasPrefix(fields[0], "/") {
+		if at := strings.Index(fields[0], "@"); at != -1 && strings.EqualFold(fields[0][at+1:], bot.UserName) {
+			return true
+		}
+	}
+
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == bot.ID {
+		return true
+	}
+
+	if message.Entities == nil {
+		return false
+	}
+
+	// Entity offsets count UTF-16 code units
+	text := utf16.Encode([]rune(message.Text))
+	for _, e := range *message.Entities {
+		switch e.Type {
+		case "mention":
+			if e.Offset < 0 || e.Offset+e.Length > len(text) {
+				continue
+			}
+			mention := string(utf16.Decode(text[e.Offset : e.Offset+e.Length]))
+			if strings.EqualFold(mention, "@"+bot.UserName) {
+				return true
+			}
+		case "text_mention":
+			if e.User != nil && e.User.ID == bot.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/monebot/util"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// AliasCommand saves the first command of param as an alias to the second,
+// e.g. /alias memes.abraco memes.hug. Names without a pack are in the chat's
+// pack. Commands already named as the alias would always be found before
+// it, so they are only replaced, with all their answers, given --force,
+// which in groups only admins can
+func AliasCommand(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, pack, param string) (ans monebot.Answer) {
+	var fields []string
+	force := false
+	for _, f := range strings.Fields(param) {
+		if f == "--force" {
+			force = true
+		} else {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) != 2 {
+		ans.Text, ans.Parse = monebot.MessageAliasUsage()
+		return
+	}
+	if force && !CanChangeSettings(bot, message.Chat, message.From) {
+		ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+		return
+	}
+	alias, target := commandRef(fields[0], pack), commandRef(fields[1], pack)
+
+	c, err := db.FindCommand(ctx, target.Pack, target.Name, monebot.AnyParams)
+	if err == monebot.ErrNotFound {
+		ans.Text, ans.Parse = monebot.MessageUnknownCommand(target.Name)
+		return
+	} else if err == monebot.ErrAliasCycle || err == nil && createsCycle(alias, target, c.Via) {
+		ans.Text, ans.Parse = monebot.MessageAliasCycle()
+		return
+	} else if err != nil {
+		log.Printf("Error finding command %s: %s", target.FullName(), err)
+		return
+	}
+
+	existing, err := db.ListCommands(ctx, alias.Pack)
+	if err != nil {
+		log.Println("Error listing commands:", err)
+		return WriteFailed(err)
+	}
+	for _, e := range existing {
+		if e.Alias != nil || util.NormalizeName(e.Name) != alias.Name {
+			continue
+		}
+		if !force {
+			ans.Text, ans.Parse = monebot.MessageAliasShadowed(alias)
+			return
+		}
+		if err := db.RemoveCommand(ctx, e.Pack, e.Name, e.Answer.NumParams); err != nil && err != monebot.ErrNotFound {
+			log.Printf("Error removing command %s: %s", e.FullName(), err)
+			return WriteFailed(err)
+		}
+	}
+
+	a := monebot.NewAlias(alias.Pack, alias.Name, target)
+	a.Creator = message.From.String()
+	if err := db.UpsertCommand(ctx, a); err != nil {
+		log.Printf("Error saving alias %s: %s", alias.FullName(), err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageSavedAlias(a, c)
+	return
+}
+
+// commandRef returns the command named by s, in pack unless s has one
+func commandRef(s, pack string) monebot.CommandRef {
+	p, name, explicit := SplitCmdName(strings.TrimPrefix(s, "/"))
+	if explicit {
+		pack = p
+	}
+	return monebot.CommandRef{Pack: pack, Name: util.NormalizeName(name)}
+}
+
+// createsCycle reports whether alias would be found while resolving target,
+// through via. An alias in the default pack is found for any pack
+func createsCycle(alias, target monebot.CommandRef, via []monebot.Command) bool {
+	refs := []monebot.CommandRef{target}
+	for _, c := range via {
+		refs = append(refs, *c.Alias)
+	}
+
+	for _, r := range refs {
+		if util.NormalizeName(r.Name) == alias.Name && (r.Pack == alias.Pack || alias.Pack == "") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+)
+
+// backupTimeout is how long a scheduled backup may take
+const backupTimeout = 10 * time.Minute
+
+// ScheduleBackups backs up the database into dir every interval, keeping
+// only the newest keep archives, until stop is closed
+func ScheduleBackups(db *monebot.Database, dir string, interval time.Duration, keep int, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		path, err := backup(db, dir)
+		if err != nil {
+			log.Println("Error backing up database:", err)
+			continue
+		}
+		log.Println("Backed up database to", path)
+
+		if err := pruneBackups(dir, keep); err != nil {
+			log.Println("Error pruning backups:", err)
+		}
+	}
+}
+
+func backup(db *monebot.Database, dir string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+
+	// Write to a temporary file first, so a failed backup is never
+	// mistaken for a complete one
+	path := filepath.Join(dir, fmt.Sprintf("monebot-%s.tar.gz", time.Now().UTC().Format("20060102-150405")))
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := db.Backup(ctx, f); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(f.Name(), path)
+}
+
+// pruneBackups removes all but the newest keep archives in dir
+func pruneBackups(dir string, keep int) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Names sort by time, oldest first
+	var backups []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), "monebot-") && strings.HasSuffix(f.Name(), ".tar.gz") {
+			backups = append(backups, f.Name())
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// Kinds of input a step prompts for
+const (
+	PromptText = iota
+	PromptMedia
+	PromptTextOrMedia
+	PromptChoice
+)
+
+// Labels of the buttons added to choice keyboards
+const (
+	backLabel   = "« Back"
+	cancelLabel = "✖ Cancel"
+)
+
+// Input is a message received for a step, either text or a media file
+type Input struct {
+	Text      string
+	MediaKind string
+	MediaID   string
+}
+
+// InvalidInput is returned by a step's validation, and sent to the user
+// before prompting the step again
+type InvalidInput string
+
+func (e InvalidInput) Error() string {
+	return string(e)
+}
+
+// Step asks the user for one value of a flow
+type Step struct {
+	// Key under which the value is kept in the flow data. Media values also
+	// keep their kind under Key + "Kind"
+	Key    string
+	Kind   int
+	Prompt func(data map[string]string) (Text, Parse string)
+
+	// Choices for PromptChoice steps
+	Choices func(data map[string]string) []string
+
+	// Validate optionally checks the input, returning the value to keep, and
+	// may change other values of data. Returning InvalidInput prompts again
+	Validate func(ctx context.Context, db monebot.Store, message *tgbotapi.Message, in Input, data map[string]string) (string, error)
+}
+
+// Flow is a named sequence of steps, finished once all values are given.
+// Command is what users send to start it, without the slash
+type Flow struct {
+	Name    string
+	Command string
+	Steps   []Step
+	Done    func(ctx context.Context, db monebot.Store, message *tgbotapi.Message, data map[string]string) monebot.Answer
+}
+
+// Flows are keyed by their names, as persisted in State
+var Flows = map[string]*Flow{}
+
+// RegisterFlow makes the flow available to StartFlow
+func RegisterFlow(f *Flow) {
+	Flows[f.Name] = f
+}
+
+// StartFlow begins the named flow for the user, with data holding any
+// values already known. Steps whose values are given are validated and
+// skipped. It returns the answer and keyboard to send
+func StartFlow(ctx context.Context, db monebot.Store, message *tgbotapi.Message, name string, data map[string]string) (monebot.Answer, interface{}) {
+	f := Flows[name]
+	s := monebot.NewFlowState(message.Chat.ID, message.From.ID, name, data)
+
+	for s.Step < len(f.Steps) {
+		step := f.Steps[s.Step]
+		value, given := s.Data[step.Key]
+		if !given {
+			break
+		}
+
+		in := Input{Text: value, MediaKind: s.Data[step.Key+"Kind"], MediaID: value}
+		if ans, invalid := f.accept(ctx, db, message, &s, in); invalid {
+			delete(s.Data, step.Key)
+			return f.save(ctx, db, s, ans)
+		}
+	}
+
+	return f.next(ctx, db, message, s)
+}
+
+// ContinueFlow feeds the message to the user's pending flow, returning
+// false if there is none
+func ContinueFlow(ctx context.Context, db monebot.Store, message *tgbotapi.Message) (ans monebot.Answer, markup interface{}, ok bool) {
+	s, err := db.FindState(ctx, message.Chat.ID, message.From.ID)
+	if err != nil {
+		if err != monebot.ErrNotFound {
+			log.Println("Error finding state:", err)
+		}
+		return
+	}
+
+	f, ok := Flows[s.Flow]
+	if !ok {
+		log.Printf("Unknown flow '%s' of %d in %d\n", s.Flow, s.User, s.Chat)
+		return
+	}
+
+	// The sweeper may not have removed it yet
+	if Expired(s) {
+		if err := db.RemoveState(ctx, s.Chat, s.User); err != nil {
+			log.Println("Error removing state:", err)
+			return
+		}
+		ans.Text, ans.Parse = monebot.MessageStateExpired(s.User, stateTTL, f.Command)
+		return ans, hideKeyboard(), true
+	}
+
+	switch message.Text {
+	case backLabel:
+		ans, markup = Back(ctx, db, message)
+		return ans, markup, true
+	case cancelLabel:
+		return Cancel(ctx, db, message), hideKeyboard(), true
+	}
+
+	in, supported := readInput(message)
+	if !supported {
+		text, parse := monebot.MessageUnsupportedInput()
+		ans, markup = f.prompt(s, text, parse)
+		return ans, markup, true
+	}
+
+	if ans, invalid := f.accept(ctx, db, message, &s, in); invalid {
+		ans, markup = f.save(ctx, db, s, ans)
+		return ans, markup, true
+	}
+
+	ans, markup = f.next(ctx, db, message, s)
+	return ans, markup, true
+}
+
+// Back prompts again the previous step of the user's pending flow
+func Back(ctx context.Context, db monebot.Store, message *tgbotapi.Message) (ans monebot.Answer, markup interface{}) {
+	s, err := db.FindState(ctx, message.Chat.ID, message.From.ID)
+	if err == monebot.ErrNotFound {
+		ans.Text, ans.Parse = monebot.MessageNoConversation()
+		return
+	} else if err != nil {
+		log.Println("Error finding state:", err)
+		return
+	}
+
+	f, ok := Flows[s.Flow]
+	if !ok {
+		return
+	}
+
+	if s.Step > 0 {
+		s.Step--
+		delete(s.Data, f.Steps[s.Step].Key)
+		delete(s.Data, f.Steps[s.Step].Key+"Kind")
+	}
+	return f.save(ctx, db, s, monebot.Answer{})
+}
+
+// accept validates the input for the current step of the state, keeping its
+// value and moving to the next step. If the input is invalid, it returns the
+// answer prompting for it again
+func (f *Flow) accept(ctx context.Context, db monebot.Store, message *tgbotapi.Message, s *monebot.State, in Input) (ans monebot.Answer, invalid bool) {
+	step := f.Steps[s.Step]
+
+	var err error
+	value := in.Text
+	switch step.Kind {
+	case PromptText:
+		if in.Text == "" || in.MediaKind != "" {
+			err = InvalidInput(monebot.MessageExpectedText())
+		}
+	case PromptMedia:
+		if in.MediaKind == "" {
+			err = InvalidInput(monebot.MessageExpectedMedia())
+		}
+		value = in.MediaID
+	case PromptTextOrMedia:
+		if in.MediaKind != "" {
+			value = in.MediaID
+		}
+	case PromptChoice:
+		err = InvalidInput(monebot.MessageExpectedChoice())
+		for _, choice := range step.Choices(s.Data) {
+			if in.Text == choice {
+				err = nil
+			}
+		}
+	}
+
+	if err == nil && step.Validate != nil {
+		value, err = step.Validate(ctx, db, message, in, s.Data)
+	}
+
+	if err != nil {
+		if invalidInput, ok := err.(InvalidInput); ok {
+			ans.Text = string(invalidInput) + "\n\n"
+		} else {
+			log.Printf("Error validating step %s of %s: %s\n", step.Key, f.Name, err)
+		}
+		return ans, true
+	}
+
+	s.Data[step.Key] = value
+	if in.MediaKind != "" {
+		s.Data[step.Key+"Kind"] = in.MediaKind
+	}
+	s.Step++
+	return ans, false
+}
+
+// next finishes the flow if all steps are done, or prompts the next step
+func (f *Flow) next(ctx context.Context, db monebot.Store, message *tgbotapi.Message, s monebot.State) (monebot.Answer, interface{}) {
+	if s.Step < len(f.Steps) {
+		return f.save(ctx, db, s, monebot.Answer{})
+	}
+
+	ans := f.Done(ctx, db, message, s.Data)
+	if err := db.RemoveState(ctx, s.Chat, s.User); err != nil && err != monebot.ErrNotFound {
+		log.Println("Error removing state:", err)
+	}
+	return ans, hideKeyboard()
+}
+
+// save persists the state and prompts its current step, after the text
+// already in ans, if any
+func (f *Flow) save(ctx context.Context, db monebot.Store, s monebot.State, ans monebot.Answer) (monebot.Answer, interface{}) {
+	s.LastUpdate = time.Now()
+	if err := db.UpsertState(ctx, s); err != nil {
+		log.Println("Error saving state:", err)
+		return WriteFailed(err), nil
+	}
+
+	text, parse := f.Steps[s.Step].Prompt(s.Data)
+	return f.prompt(s, ans.Text+text, parse)
+}
+
+// prompt returns the answer with the text and the keyboard for the current
+// step
+func (f *Flow) prompt(s monebot.State, text, parse string) (ans monebot.Answer, markup interface{}) {
+	step := f.Steps[s.Step]
+	ans.Text, ans.Parse = text, parse
+
+	var rows [][]tgbotapi.KeyboardButton
+	if step.Kind == PromptChoice {
+		for _, choice := range step.Choices(s.Data) {
+			rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(choice)))
+		}
+	}
+
+	controls := []tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButton(cancelLabel)}
+	if s.Step > 0 {
+		controls = append([]tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButton(backLabel)}, controls...)
+	}
+	rows = append(rows, controls)
+
+	keyboard := tgbotapi.NewReplyKeyboard(rows...)
+	keyboard.OneTimeKeyboard = true
+	keyboard.Selective = true
+	return ans, keyboard
+}
+
+// readInput returns the text or media of the message, or false if the
+// message has neither
+func readInput(message *tgbotapi.Message) (Input, bool) {
+	switch {
+	case message.Sticker != nil:
+		return Input{MediaKind: "sticker", MediaID: message.Sticker.FileID}, true
+	case message.Photo != nil && len(*message.Photo) > 0:
+		photos := *message.Photo
+		return Input{MediaKind: "photo", MediaID: photos[len(photos)-1].FileID, Text: message.Caption}, true
+	case message.Document != nil:
+		return Input{MediaKind: "document", MediaID: message.Document.FileID, Text: message.Caption}, true
+	case message.Voice != nil:
+		return Input{MediaKind: "voice", MediaID: message.Voice.FileID}, true
+	case message.Text != "":
+		return Input{Text: message.Text}, true
+	}
+	return Input{}, false
+}
+
+// hideKeyboard removes the keyboard of a finished flow
+func hideKeyboard() interface{} {
+	return tgbotapi.ReplyKeyboardHide{HideKeyboard: true, Selective: true}
+}
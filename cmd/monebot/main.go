@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/victormoneratto/monebot"
@@ -14,136 +18,540 @@ import (
 	"github.com/victormoneratto/telegram-bot-api"
 )
 
-var reply struct {
-	asd int
-}
+// Once the bot is asked to stop, in-flight updates have shutdownTimeout to
+// finish, and then abortTimeout to give up, before the process exits (heroku
+// kills it 30 seconds after SIGTERM)
+const (
+	shutdownTimeout = 20 * time.Second
+	abortTimeout    = 5 * time.Second
+)
+
+// updateTimeout is how long the database operations of a single update may
+// take in total
+const updateTimeout = 20 * time.Second
+
+// updateLease is how long an update claimed by this process is reserved to
+// it, longer than answering it may take
+const updateLease = time.Minute
+
+// migrateTimeout is how long all pending migrations may take
+const migrateTimeout = 10 * time.Minute
+
+// reserved are the built-in commands the bot always had. Chats may have
+// saved commands named like any built-in added later, so saved commands of
+// the same name override those
+var reserved = map[string]bool{"neverforget": true, "never4get": true, "i": true}
+
+// Lookups of packs and commands are cached, up to cacheSize of each, for
+// cacheTTL (changes made elsewhere take up to cacheTTL to be seen)
+const (
+	cacheSize = 1000
+	cacheTTL  = 5 * time.Minute
+)
 
 func main() {
 	// Setup logging for heroku
 	log.SetOutput(os.Stdout)
 	log.SetFlags(0)
 
+	// Stop on SIGTERM (heroku) or SIGINT (terminal)
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down\n", sig)
+		close(stop)
+	}()
+
+	connURI := util.MustGetenv("DATABASE_CONN_URI")
+
+	// Only migrate the database, e.g. "monebot migrate -dry-run"
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "only show what would be migrated")
+		flags.Parse(os.Args[2:])
+
+		db, err := monebot.NewDatabase(connURI)
+		if err != nil {
+			log.Fatalln("Error connecting to database:", err)
+		}
+		defer db.Close()
+
+		if err := migrate(db, *dryRun); err != nil {
+			log.Fatalln("Error migrating database:", err)
+		}
+		return
+	}
+
+	token := util.MustGetenv("TELEGRAM_BOT_TOKEN")
+
 	// Connect to telegram
-	bot, err := tgbotapi.NewBotAPI(util.MustGetenv("TELEGRAM_BOT_TOKEN"))
+	var bot *tgbotapi.BotAPI
+	err := util.Backoff(time.Second, time.Minute, stop, func() (err error) {
+		bot, err = tgbotapi.NewBotAPI(token)
+		return
+	})
 	if err != nil {
-		panic(err)
+		log.Println("Error connecting to telegram:", err)
+		return
 	}
 
 	// Connect to database
-	db, err := monebot.NewDatabase(util.MustGetenv("DATABASE_CONN_URI"))
+	var database *monebot.Database
+	err = util.Backoff(time.Second, time.Minute, stop, func() (err error) {
+		database, err = monebot.NewDatabase(connURI)
+		return
+	})
 	if err != nil {
-		panic(err)
+		log.Println("Error connecting to database:", err)
+		return
 	}
-	defer db.Close()
+	defer database.Close()
 
-	// Listen for updates
-	updates, err := bot.GetUpdatesChan(tgbotapi.NewUpdate(0))
-	if err != nil {
-		panic(err)
+	// Bring the data up to date before using it
+	if err := migrate(database, false); err != nil {
+		log.Println("Error migrating database:", err)
+		return
 	}
 
-	log.Printf("@%s started\n", bot.Self.UserName)
+	db := monebot.NewCache(database, cacheSize, cacheTTL)
+	defer func() {
+		stats := db.Stats()
+		log.Printf("Cache hits: %d, misses: %d, stale: %d\n", stats.Hits, stats.Misses, stats.Stale)
+	}()
+
+	// Back up the database periodically, if a directory is given
+	var background sync.WaitGroup
+	if dir := os.Getenv("BACKUP_DIR"); dir != "" {
+		interval, err := time.ParseDuration(util.GetenvDefault("BACKUP_INTERVAL", "24h"))
+		if err != nil {
+			log.Println("Invalid BACKUP_INTERVAL:", err)
+			return
+		}
+		keep, err := strconv.Atoi(util.GetenvDefault("BACKUP_KEEP", "7"))
+		if err != nil {
+			log.Println("Invalid BACKUP_KEEP:", err)
+			return
+		}
 
-	// Indefinitely loop for updates
-	for update := range updates {
+		background.Add(1)
 		go func() {
-			var ans monebot.Answer
-			var reply struct {
-				To int
-				//forceReply tgbotapi.ForceReply
+			defer background.Done()
+			ScheduleBackups(database, dir, interval, keep, stop)
+		}()
+	}
+
+	// Give up on abandoned conversations
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		SweepStates(bot, db, stop)
+	}()
+
+	// Identifies this process in the leases of the updates it claims and of
+	// the scheduler
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d", host, os.Getpid())
+
+	// Post reminders and scheduled commands when they are due
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		RunScheduler(bot, db, owner, stop)
+	}()
+
+	// Resume from the last fully processed update
+	var offset int
+	err = util.Backoff(time.Second, time.Minute, stop, func() (err error) {
+		ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+		defer cancel()
+		offset, err = db.FindOffset(ctx)
+		return
+	})
+	if err != nil {
+		log.Println("Error finding offset:", err)
+		return
+	}
+	tracker := NewOffsetTracker(offset)
+
+	// Listen for updates until asked to stop
+	updates := PollUpdates(bot, offset, stop)
+
+	log.Printf("@%s started from update %d\n", bot.Self.UserName, offset)
+
+	// Cancelled if in-flight updates take too long to drain on shutdown
+	base, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	// Stops right away, without waiting for the long poll to return
+	var handlers sync.WaitGroup
+poll:
+	for {
+		var update tgbotapi.Update
+		select {
+		case <-stop:
+			break poll
+		case u, ok := <-updates:
+			if !ok {
+				break poll
+			}
+			update = u
+		}
+
+		if !tracker.Start(update.UpdateID) {
+			log.Printf("Skipping update %d already in flight\n", update.UpdateID)
+			continue
+		}
+
+		handlers.Add(1)
+		go func(update tgbotapi.Update) {
+			defer handlers.Done()
+
+			// Retried while the database fails, but isn't known to be down,
+			// so that the offset never moves past an update left unanswered
+			var claimErr error
+			err := util.Backoff(time.Second, 10*time.Second, base.Done(), func() error {
+				ctx, cancel := context.WithTimeout(base, updateTimeout)
+				defer cancel()
+
+				claimErr = db.ClaimUpdate(ctx, update.UpdateID, owner, updateLease)
+				if claimErr == monebot.ErrReadOnly || claimErr == monebot.ErrDuplicate {
+					return nil
+				}
+				return claimErr
+			})
+			if err != nil {
+				log.Printf("Giving up on update %d: %s\n", update.UpdateID, claimErr)
+				return
 			}
 
-			if update.Message == nil {
-				log.Printf("Received unsupported update: %#v\n", update)
+			switch claimErr {
+			case monebot.ErrReadOnly:
+				// Can't deduplicate without the database, answer anyway
+				log.Printf("Handling update %d in read-only mode\n", update.UpdateID)
+			case monebot.ErrDuplicate:
+				log.Printf("Skipping update %d already processed elsewhere\n", update.UpdateID)
+				tracker.Done(update.UpdateID)
 				return
 			}
 
-			message := update.Message
+			ctx, cancel := context.WithTimeout(base, updateTimeout)
+			defer cancel()
 
-			log.Printf("Received: '%s' from %s\n", message.Text, message.From)
+			HandleUpdate(ctx, bot, db, update)
 
-			if message.IsCommand() {
-				pack, name, explicitPack := SplitCmdName(message.Command())
-				if !explicitPack {
-					pack, err = db.FindPack(message.Chat.ID)
-					if err != nil {
-						log.Println("Error finding pack:", err)
-						pack = ""
-					}
-				}
+			if err := db.FinishUpdate(ctx, update.UpdateID); err != nil {
+				log.Printf("Error finishing update %d: %s\n", update.UpdateID, err)
+			}
+			if err := db.SaveOffset(ctx, tracker.Done(update.UpdateID)); err != nil {
+				log.Println("Error saving offset:", err)
+			}
+		}(update)
+	}
 
-				param := message.CommandArguments()
-
-				switch  name {
-
-				case "neverforget":
-					fallthrough
-				case "never4get":
-					//if space := strings.IndexFunc(param, unicode.IsSpace()) {
-					//
-					//}
-
-
-				case "i":
-					// Show info about command
-					paramSlice := SplitParams(param)
-					c, err := db.FindCommand(pack, name, len(paramSlice))
-					if err != nil {
-						log.Printf("Error finding command '%s.%s': %s", pack, name, err)
-						return
-					}
-
-					ans.Text, ans.Parse = monebot.MessageCommandInfo(c)
-
-				default:
-					// Search for a saved command
-					paramSlice := SplitParams(param)
-					c, err := db.FindCommand(pack, name, len(paramSlice))
-					if err != nil {
-						log.Printf("Error finding command %s.%s %v: %s", pack, name, param, err)
-					}
-
-					ans = c.Answer
-					if c.Answer.NumParams > 0 {
-						p := make([]interface{}, 0, len(paramSlice))
-						for _, param := range paramSlice {
-							p = append(p, param)
-						}
-						ans.Text = fmt.Sprintf(ans.Text, p...)
-					}
-
-					if update.Message.ReplyToMessage != nil {
-						reply.To = update.Message.ReplyToMessage.MessageID
-					}
-
-					log.Printf("Answering known command from %s: %s.%s [%s]\n", update.Message.From, pack, name, param)
-				}
-			} else {
+	// Drain in-flight updates and backups before closing the database
+	drained := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		background.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("All updates processed, bye")
+	case <-time.After(shutdownTimeout):
+		log.Println("Timed out waiting for in-flight updates")
+		abort()
+
+		// The database can only be closed once nothing uses it
+		select {
+		case <-drained:
+		case <-time.After(abortTimeout):
+			log.Println("Exiting with updates still in flight")
+			os.Exit(1)
+		}
+	}
+}
+
+// migrate runs the pending database migrations
+func migrate(db *monebot.Database, dryRun bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	ran, err := db.Migrate(ctx, dryRun)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		log.Printf("%d pending migrations\n", len(ran))
+	} else if len(ran) > 0 {
+		log.Printf("Applied %d migrations\n", len(ran))
+	}
+	return nil
+}
+
+// HandleUpdate answers a single update from telegram
+func HandleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, update tgbotapi.Update) {
+	var ans monebot.Answer
+	var markup interface{}
+	var send tgbotapi.Chattable
+	var reroll *monebot.Reroll
+	var reply struct {
+		To int
+	}
+
+	if update.CallbackQuery != nil {
+		HandleCallback(ctx, bot, db, update.CallbackQuery)
+		return
+	}
+
+	if update.Message == nil {
+		log.Printf("Received unsupported update: %#v\n", update)
+		return
+	}
+
+	message := update.Message
+
+	log.Printf("Received: '%s' from %s\n", message.Text, message.From)
+
+	settings, err := db.FindSettings(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error finding settings:", err)
+	}
+
+	if message.IsCommand() {
+		command, forBot := CommandFor(message.Text, bot.Self.UserName)
+		if !forBot {
+			log.Printf("Ignoring command '%s' for another bot\n", command)
+			return
+		}
+		if settings.OnlyAddressed && !AddressedToBot(message, bot.Self) {
+			// Only /command@bot, mentions and replies are for the bot
+			log.Printf("Ignoring command '%s' not addressed to the bot\n", command)
+			return
+		}
+
+		pack, name, explicitPack := SplitCmdName(command)
+		if !explicitPack {
+			var err error
+			pack, err = db.FindPack(ctx, message.Chat.ID)
+			if err != nil {
+				log.Println("Error finding pack:", err)
+				pack = ""
+			}
+		}
+
+		param := message.CommandArguments()
 
+		// Saved commands named like a newer built-in take its place
+		builtin := util.NormalizeName(name)
+		if !reserved[builtin] {
+			if _, err := db.FindCommand(ctx, pack, name, len(SplitParams(param))); err == nil {
+				builtin = ""
 			}
+		}
+
+		switch builtin {
+
+		case "neverforget":
+			fallthrough
+		case "never4get":
+			// Save a command, asking for what is missing
+			ans, markup = NeverForget(ctx, db, message, pack, param)
+
+		case "back":
+			// Go back to the previous step of the pending conversation
+			ans, markup = Back(ctx, db, message)
 
+		case "cancel":
+			// Forget the pending conversation
+			ans, markup = Cancel(ctx, db, message), hideKeyboard()
 
+		case "settings":
+			// Show the chat's settings as buttons
+			send, ans = SettingsMenu(ctx, bot, db, message)
 
-			var send tgbotapi.Chattable
-			if ans.Sticker != "" {
-				sticker := tgbotapi.NewStickerShare(update.Message.Chat.ID, ans.Sticker)
-				send = sticker
-			} else if ans.Text != "" {
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, ans.Text)
-				msg.ParseMode = ans.Parse
-				msg.ReplyToMessageID = reply.To
-				msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply:true, Selective: true}
-				send = msg
+		case "pack":
+			// Export or import the pack as a bundle
+			send, ans = PackCommand(ctx, bot, db, message, pack, param)
+
+		case "var":
+			// Set, show or reset a variable used in answers
+			ans = VarCommand(ctx, bot, db, message, param)
+
+		case "when":
+			// Add a variant of a command's answer for some conditions
+			ans = WhenCommand(ctx, db, message, pack, param)
+
+		case "alias":
+			// Make a name resolve to another command
+			ans = AliasCommand(ctx, bot, db, message, pack, param)
+
+		case "i":
+			// Show info about the command given, with the parameters after it
+			fields := strings.SplitN(param, " ", 2)
+			if fields[0] == "" {
+				ans.Text, ans.Parse = monebot.MessageMissingName()
+				break
 			}
 
-			if send != nil {
-				_, err = bot.Send(send)
+			info := commandRef(fields[0], pack)
+			var paramSlice []string
+			if len(fields) > 1 {
+				paramSlice = SplitParams(fields[1])
+			}
+
+			c, err := db.FindCommand(ctx, info.Pack, info.Name, len(paramSlice))
+			if err == monebot.ErrNotFound || err == monebot.ErrAliasCycle {
+				ans.Text, ans.Parse = monebot.MessageUnknownCommand(info.Name)
+				break
+			} else if err != nil {
+				log.Printf("Error finding command '%s': %s", info.FullName(), err)
+				return
+			}
+
+			ans.Text, ans.Parse = monebot.MessageCommandInfo(c)
+
+		case "remindme":
+			// Remind the sender, e.g. /remindme 2h take out trash
+			ans = RemindMe(ctx, db, message, settings, param)
+
+		case "remind":
+			// Remind someone, e.g. /remind @alice tomorrow 9:00 standup
+			ans = Remind(ctx, db, message, settings, param)
+
+		case "reminders":
+			// List or cancel the chat's reminders
+			ans = Reminders(ctx, db, message, settings, param)
+
+		case "schedule":
+			// Post a command periodically, e.g. /schedule "0 9 * * MON" pack.weekly
+			ans = ScheduleCommand(ctx, bot, db, message, settings, pack, param)
+
+		case "schedules":
+			// List or cancel the chat's schedules
+			ans = Schedules(ctx, bot, db, message, settings, param)
+
+		case "timezone":
+			// Show or set the chat's time zone
+			ans = Timezone(ctx, bot, db, message, settings, param)
+
+		case "roll":
+			// Roll dice, e.g. /roll 2d6+3
+			ans = Roll(param)
+
+		case "flip":
+			// Flip a coin
+			ans = Flip()
+
+		case "pick":
+			// Pick one of the options, e.g. /pick pizza, sushi
+			ans = Pick(param)
+
+		default:
+			// Search for a saved command
+			paramSlice := SplitParams(param)
+			c, err := db.FindCommand(ctx, pack, name, len(paramSlice))
+			if err == monebot.ErrNotFound && !settings.SilentUnknown {
+				ans.Text, ans.Parse = monebot.MessageUnknownCommand(name)
+				break
+			} else if err != nil {
+				log.Printf("Error finding command %s.%s %v: %s", pack, name, param, err)
+				return
+			}
+
+			now := time.Now()
+			c.Answer = c.AnswerFor(message.Chat.ID, message.From.UserName, now.In(settings.Location()))
+			ans = picker.Pick(message.Chat.ID, c)
+			if ans.Parse == "" {
+				ans.Parse = settings.ParseMode
+			}
+			if ans.Text != "" && len(c.Answer.Texts().Choices()) > 1 {
+				// Let the chat ask for another text
+				reroll = &monebot.Reroll{Chat: message.Chat.ID, Command: monebot.CommandRef{Pack: c.Pack, Name: c.Name},
+					Params: paramSlice, User: message.From.UserName, Text: ans.Text, Time: now}
+				markup = rerollKeyboard()
+			}
+			if ans.Text != "" {
+				ans.Text, err = monebot.NewRenderer(db, message.Chat.ID).Render(ctx, c, ans.Text, paramSlice)
 				if err != nil {
-					log.Println("Error sending message:", err)
+					log.Printf("Error rendering command %s: %s", c.FullName(), err)
+					ans.Text, ans.Parse = monebot.MessageRenderFailed(err)
+					reroll, markup = nil, nil
 				}
 			}
-		}()
+
+			if message.ReplyToMessage != nil && settings.ReplyToReplied {
+				reply.To = message.ReplyToMessage.MessageID
+			}
+
+			log.Printf("Answering known command from %s: %s.%s [%s]\n", message.From, pack, name, param)
+		}
+	} else {
+		// Continue a pending conversation, if any. Its messages are for the
+		// bot even if they don't mention it, as the user started it with a
+		// command addressed to the bot
+		ans, markup, _ = ContinueFlow(ctx, db, message)
+	}
+
+	if send != nil {
+		// Already built by the command
+	} else if ans.Sticker != "" {
+		sticker := tgbotapi.NewStickerShare(message.Chat.ID, ans.Sticker)
+		send = sticker
+	} else if ans.Text != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, ans.Text)
+		msg.ParseMode = ans.Parse
+		msg.ReplyToMessageID = reply.To
+		if settings.ForceReply {
+			msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+		}
+		if markup != nil {
+			msg.ReplyMarkup = markup
+		}
+		send = msg
 	}
+
+	if send != nil {
+		sent, err := bot.Send(send)
+		if err != nil {
+			log.Println("Error sending message:", err)
+			return
+		}
+
+		if reroll != nil {
+			reroll.Message = sent.MessageID
+			if err := db.SaveReroll(ctx, *reroll); err != nil {
+				log.Println("Error saving reroll:", err)
+			}
+		}
+	}
+}
+
+// HandleCallback answers a button pressed in an inline keyboard
+func HandleCallback(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, query *tgbotapi.CallbackQuery) {
+	log.Printf("Received callback: '%s' from %s\n", query.Data, query.From)
+
+	switch {
+	case strings.HasPrefix(query.Data, settingsPrefix):
+		SettingsCallback(ctx, bot, db, query)
+	case query.Data == rerollData:
+		RerollCallback(ctx, bot, db, query)
+	default:
+		log.Printf("Unknown callback: '%s'\n", query.Data)
+	}
+}
+
+// WriteFailed returns the answer for a failed write, telling the user when
+// the bot is in read-only mode
+func WriteFailed(err error) (ans monebot.Answer) {
+	if err == monebot.ErrReadOnly {
+		ans.Text, ans.Parse = monebot.MessageReadOnly()
+	}
+	return
 }
 
 func SplitCmdName(c string) (pack, name string, explicit bool) {
@@ -164,79 +572,27 @@ func SplitCmdName(c string) (pack, name string, explicit bool) {
 }
 
 func SplitParams(p string) []string {
+	if p == "" {
+		return nil
+	}
 	return strings.Split(p, ", ")
 }
 
-func NewTextAnswer(text string) monebot.Answer {
-	text = RemoveUnsupportedVerbs(text)
-	return monebot.Answer{Text: text, NumParams: CountVerbs(text)}
-}
-
-func NewStickerAnswer(sticker string) monebot.Answer {
-	return monebot.Answer{Sticker: sticker}
-}
-
 // saveCommand updates or inserts a command
-func SaveCommand(pack, name, creator string, ans monebot.Answer, db *monebot.Database) (monebot.Command, error) {
+func SaveCommand(ctx context.Context, pack, name, creator string, ans monebot.Answer, db monebot.Store) (monebot.Command, error) {
 	var c monebot.Command
 	var err error
 
 	c.Pack = pack
-	c.Name = name
+	c.Name = util.NormalizeName(name)
 	c.Answer = ans
 	c.Creator = creator
 	c.Time = time.Now()
 
-	err = db.UpsertCommand(c)
+	err = db.UpsertCommand(ctx, c)
 	if err != nil {
 		return c, err
 	}
 
 	return c, nil
 }
-
-// CountVerbs returns the number of string verbs (%s, %[1]s...)
-// taking into consideration indexed and non-indexed verbs
-func CountVerbs(s string) int {
-	matches := regexp.MustCompile("%(?:\\[(\\d+)\\])?s").FindAllStringSubmatch(s, -1)
-	var numNotIndexed, maxIndex int
-	for _, submatches := range matches {
-		if indexStr := submatches[len(submatches) - 1]; indexStr == "" {
-			numNotIndexed++
-		} else {
-			index, err := strconv.Atoi(indexStr)
-			if err != nil {
-				numNotIndexed++
-				continue
-			}
-			if index > maxIndex {
-				maxIndex = index
-			}
-		}
-	}
-	if maxIndex > numNotIndexed {
-		return maxIndex
-	}
-	return numNotIndexed
-}
-
-// RemoveUnsupportedVerbs returns a cleaner version of a format string,
-// trying to replace most unsupported Printf verbs (%d, %[1]v, %#v etc.)
-func RemoveUnsupportedVerbs(s string) string {
-	return regexp.MustCompile("%#?(?:\\[\\d+\\])?[^%s\\s\\[]").ReplaceAllStringFunc(s,
-		func(match string) string {
-			start := strings.IndexRune(match, '[')
-			end := strings.IndexRune(match, ']')
-
-			// Handle indexed verb
-			if start < end {
-				index, err := strconv.Atoi(match[start + 1 : end])
-				if err != nil {
-					return "%s"
-				}
-				return fmt.Sprintf("%%[%d]s", index)
-			}
-
-			return "%s"
-		})
-}
\ No newline at end of file
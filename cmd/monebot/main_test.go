@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
 
 func TestSplitCmdName(t *testing.T) {
 	if pack, name, explicit := SplitCmdName("pack.name");
@@ -19,22 +24,87 @@ func TestSplitCmdName(t *testing.T) {
 	}
 }
 
-func TestCountVerbs(t *testing.T) {
-	if n := CountVerbs("%s"); n != 1 {
-		t.Error("Expected 1, got", n)
+func TestOffsetTracker(t *testing.T) {
+	tracker := NewOffsetTracker(10)
+	if tracker.Start(10) {
+		t.Error("Expected already processed update 10 to be rejected")
+	}
+
+	tracker.Start(11)
+	tracker.Start(12)
+	if tracker.Start(12) {
+		t.Error("Expected update 12 in flight to be rejected")
+	}
+
+	if offset := tracker.Done(12); offset != 10 {
+		t.Error("Expected 10 while 11 is in flight, got", offset)
+	}
+
+	if offset := tracker.Done(11); offset != 12 {
+		t.Error("Expected 12, got", offset)
+	}
+}
+
+func TestCommandFor(t *testing.T) {
+	if command, forBot := CommandFor("/hug@MoneBot me", "monebot"); command != "hug" || !forBot {
+		t.Errorf("Expected 'hug true', got '%s %t'", command, forBot)
 	}
 
-	if n := CountVerbs("%[1]s"); n != 1 {
-		t.Error("Expected 1, got", n)
+	if command, forBot := CommandFor("/pack.hug", "monebot"); command != "pack.hug" || !forBot {
+		t.Errorf("Expected 'pack.hug true', got '%s %t'", command, forBot)
 	}
 
-	if n := CountVerbs("%[2]s"); n != 2 {
-		t.Error("Expected 2, got", n)
+	if _, forBot := CommandFor("/hug@OtherBot", "monebot"); forBot {
+		t.Error("Expected command for another bot to be ignored")
 	}
 }
 
-func TestRemoveUnsupportedVerbs(t *testing.T) {
-	if s := RemoveUnsupportedVerbs("%d"); !(s == "%s") {
-		t.Error("Expected %s, got", s)
+func TestAddressedToBot(t *testing.T) {
+	bot := tgbotapi.User{ID: 1, UserName: "monebot"}
+	group := &tgbotapi.Chat{Type: "group"}
+
+	entities := []tgbotapi.MessageEntity{{Type: "mention", Offset: 3, Length: 8}}
+	message := &tgbotapi.Message{Chat: group, Text: "👋 @monebot", Entities: &entities}
+	if !AddressedToBot(message, bot) {
+		t.Error("Expected mention to be addressed to the bot")
 	}
-}
\ No newline at end of file
+
+	message = &tgbotapi.Message{Chat: group, Text: "hello"}
+	if AddressedToBot(message, bot) {
+		t.Error("Expected plain message not to be addressed to the bot")
+	}
+
+	message.ReplyToMessage = &tgbotapi.Message{From: &bot}
+	if !AddressedToBot(message, bot) {
+		t.Error("Expected reply to be addressed to the bot")
+	}
+
+	message = &tgbotapi.Message{Chat: group, Text: "/hug@MoneBot me"}
+	if !AddressedToBot(message, bot) {
+		t.Error("Expected command with the bot's name to be addressed to the bot")
+	}
+
+	message = &tgbotapi.Message{Chat: group, Text: "/hug me"}
+	if AddressedToBot(message, bot) {
+		t.Error("Expected bare command not to be addressed to the bot")
+	}
+}
+
+func TestCreatesCycle(t *testing.T) {
+	hug := monebot.CommandRef{Pack: "memes", Name: "hug"}
+	abraco := monebot.CommandRef{Pack: "memes", Name: "abraco"}
+	via := []monebot.Command{monebot.NewAlias("memes", "hug", monebot.CommandRef{Pack: "memes", Name: "abraco"})}
+
+	if createsCycle(abraco, hug, nil) {
+		t.Error("Expected no cycle aliasing abraco to hug")
+	}
+	if !createsCycle(abraco, hug, via) {
+		t.Error("Expected a cycle aliasing abraco to hug, an alias of abraco")
+	}
+	if !createsCycle(hug, hug, nil) {
+		t.Error("Expected a cycle aliasing hug to itself")
+	}
+	if !createsCycle(monebot.CommandRef{Name: "hug"}, hug, nil) {
+		t.Error("Expected a cycle aliasing the default hug to memes.hug")
+	}
+}
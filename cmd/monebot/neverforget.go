@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+func init() {
+	RegisterFlow(&Flow{
+		Name:    "neverforget",
+		Command: "neverforget",
+		Steps: []Step{
+			{
+				Key:  "name",
+				Kind: PromptText,
+				Prompt: func(data map[string]string) (string, string) {
+					return monebot.MessageMissingName()
+				},
+				Validate: validateCommandName,
+			},
+			{
+				Key:  "content",
+				Kind: PromptTextOrMedia,
+				Prompt: func(data map[string]string) (string, string) {
+					return monebot.MessageMissingContent()
+				},
+				Validate: validateCommandContent,
+			},
+		},
+		Done: saveNeverForget,
+	})
+}
+
+// NeverForget saves the command given in param as "[flags] <name> <content>",
+// asking for the name and content when they are missing. The flags are
+// --add, to add the content as an alternative of an existing command, and
+// --weight=N, how likely the content is among the alternatives
+func NeverForget(ctx context.Context, db monebot.Store, message *tgbotapi.Message, pack, param string) (monebot.Answer, interface{}) {
+	data := map[string]string{"pack": pack}
+
+	param = strings.TrimSpace(param)
+	for strings.HasPrefix(param, "--") {
+		var flag string
+		flag, param = splitFirst(param)
+
+		weight := strings.TrimPrefix(flag, "--weight=")
+		if n, err := strconv.Atoi(weight); weight != flag && err == nil && n > 0 {
+			data["weight"] = weight
+		} else if flag == "--add" {
+			data["add"] = "true"
+		} else {
+			var ans monebot.Answer
+			ans.Text, ans.Parse = monebot.MessageNeverForgetUsage()
+			return ans, nil
+		}
+	}
+
+	if param != "" {
+		name, content := splitFirst(param)
+		data["name"] = name
+		if content != "" {
+			data["content"] = content
+		}
+	}
+
+	return StartFlow(ctx, db, message, "neverforget", data)
+}
+
+// splitFirst returns the first word of s and the trimmed rest of it
+func splitFirst(s string) (first, rest string) {
+	if space := strings.IndexFunc(s, unicode.IsSpace); space != -1 {
+		return s[:space], strings.TrimSpace(s[space:])
+	}
+	return s, ""
+}
+
+// validateCommandName accepts "[pack.]name", keeping the pack apart
+func validateCommandName(ctx context.Context, db monebot.Store, message *tgbotapi.Message, in Input, data map[string]string) (string, error) {
+	name := strings.TrimPrefix(strings.TrimSpace(in.Text), "/")
+	if name == "" || strings.IndexFunc(name, unicode.IsSpace) != -1 {
+		text, _ := monebot.MessageMissingName()
+		return "", InvalidInput(text)
+	}
+
+	pack, name, explicit := SplitCmdName(name)
+	if explicit {
+		data["pack"] = pack
+	}
+	return name, nil
+}
+
+// validateCommandContent accepts text and stickers, the media answers can hold
+func validateCommandContent(ctx context.Context, db monebot.Store, message *tgbotapi.Message, in Input, data map[string]string) (string, error) {
+	switch in.MediaKind {
+	case "":
+		return in.Text, nil
+	case "sticker":
+		return in.MediaID, nil
+	}
+	return "", InvalidInput(monebot.MessageExpectedTextOrSticker())
+}
+
+func saveNeverForget(ctx context.Context, db monebot.Store, message *tgbotapi.Message, data map[string]string) (ans monebot.Answer) {
+	content := monebot.NewTextAnswer(data["content"])
+	if data["contentKind"] == "sticker" {
+		content = monebot.NewStickerAnswer(data["content"])
+	}
+	content.Weight, _ = strconv.Atoi(data["weight"])
+
+	if data["add"] != "" {
+		c, added, err := AddAlternative(ctx, data["pack"], data["name"], message.From.String(), content, db)
+		if err != nil {
+			log.Printf("Error adding to command %s.%s: %s", data["pack"], data["name"], err)
+			return WriteFailed(err)
+		}
+
+		if added {
+			ans.Text, ans.Parse = monebot.MessageAddedAlternative(c)
+		} else {
+			ans.Text, ans.Parse = monebot.MessageSavedCommand(c)
+		}
+		return
+	}
+
+	c, err := SaveCommand(ctx, data["pack"], data["name"], message.From.String(), content, db)
+	if err != nil {
+		log.Printf("Error saving command %s.%s: %s", data["pack"], data["name"], err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageSavedCommand(c)
+	return
+}
+
+// AddAlternative adds ans as one more choice of the command in the pack, or
+// of the command an alias in the pack resolves to. If there is no such
+// command with as many parameters, it is saved as a new one, returning false
+func AddAlternative(ctx context.Context, pack, name, creator string, ans monebot.Answer, db monebot.Store) (monebot.Command, bool, error) {
+	c, err := db.FindCommand(ctx, pack, name, ans.NumParams)
+	if err == monebot.ErrNotFound || err == nil && c.Pack != pack && len(c.Via) == 0 {
+		// Not adding to the default pack's command
+		c, err = SaveCommand(ctx, pack, name, creator, ans, db)
+		return c, false, err
+	} else if err != nil {
+		return c, false, err
+	}
+
+	c.Answer = c.Answer.AddAlternative(ans)
+	c.Creator = creator
+	c.Time = time.Now()
+	c.NumChanged++
+	return c, true, db.UpsertCommand(ctx, c)
+}
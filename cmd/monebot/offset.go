@@ -0,0 +1,54 @@
+package main
+
+import "sync"
+
+// OffsetTracker keeps track of the updates being processed concurrently, so
+// that only the offset of updates fully processed (with no pending update
+// before them) is persisted
+type OffsetTracker struct {
+	mu       sync.Mutex
+	inFlight map[int]bool
+	highest  int
+}
+
+// NewOffsetTracker returns a tracker resuming from the given offset
+func NewOffsetTracker(offset int) *OffsetTracker {
+	return &OffsetTracker{inFlight: make(map[int]bool), highest: offset}
+}
+
+// Start marks the update as in flight, returning false if it is already
+// being processed or was already processed by this tracker
+func (t *OffsetTracker) Start(updateID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.inFlight[updateID] || updateID <= t.offset() {
+		return false
+	}
+
+	t.inFlight[updateID] = true
+	if updateID > t.highest {
+		t.highest = updateID
+	}
+	return true
+}
+
+// Done marks the update as processed and returns the ID of the last update
+// such that all updates up to it are processed
+func (t *OffsetTracker) Done(updateID int) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.inFlight, updateID)
+	return t.offset()
+}
+
+func (t *OffsetTracker) offset() int {
+	offset := t.highest
+	for id := range t.inFlight {
+		if id-1 < offset {
+			offset = id - 1
+		}
+	}
+	return offset
+}
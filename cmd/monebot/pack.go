@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// maxBundleSize is the size of the largest bundle accepted by /pack import
+const maxBundleSize = 1 << 20
+
+// PackCommand handles "/pack export [name] [history] [yaml]", sending the
+// pack as a bundle document, and "/pack import [skip|overwrite|rename]" in
+// reply to a bundle document, importing it into the chat's own pack. In
+// groups only admins can import
+func PackCommand(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, pack, param string) (send tgbotapi.Chattable, ans monebot.Answer) {
+	args := strings.Fields(param)
+	if len(args) == 0 {
+		ans.Text, ans.Parse = monebot.MessagePackUsage()
+		return
+	}
+
+	switch args[0] {
+	case "export":
+		history, format := false, monebot.FormatJSON
+		for _, arg := range args[1:] {
+			switch arg {
+			case "history":
+				history = true
+			case monebot.FormatJSON, monebot.FormatYAML:
+				format = arg
+			default:
+				pack = arg
+			}
+		}
+
+		b, err := monebot.ExportPack(ctx, db, pack, history)
+		if err != nil {
+			log.Printf("Error exporting pack '%s': %s\n", pack, err)
+			return
+		}
+
+		var content bytes.Buffer
+		if err := monebot.WriteBundle(&content, b, format); err != nil {
+			log.Printf("Error encoding pack '%s': %s\n", pack, err)
+			return
+		}
+
+		doc := tgbotapi.NewDocumentUpload(message.Chat.ID, tgbotapi.FileBytes{
+			Name:  fmt.Sprintf("%s.monebot.%s", bundleName(pack), format),
+			Bytes: content.Bytes(),
+		})
+		doc.ReplyToMessageID = message.MessageID
+		send = doc
+
+	case "import":
+		policy := monebot.ConflictSkip
+		if len(args) > 1 {
+			policy = args[1]
+		}
+
+		if message.ReplyToMessage == nil || message.ReplyToMessage.Document == nil {
+			ans.Text, ans.Parse = monebot.MessageMissingBundle()
+			return
+		}
+
+		if !CanChangeSettings(bot, message.Chat, message.From) {
+			ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+			return
+		}
+
+		b, err := downloadBundle(ctx, bot, message.ReplyToMessage.Document.FileID)
+		if err != nil {
+			log.Println("Error downloading bundle:", err)
+			ans.Text, ans.Parse = monebot.MessageInvalidBundle(err)
+			return
+		}
+
+		// Never the pack the bundle or the command names, which other chats
+		// may use
+		pack, err = chatPack(ctx, db, message.Chat.ID)
+		if err != nil {
+			log.Println("Error finding the chat's pack:", err)
+			return nil, WriteFailed(err)
+		}
+
+		result, err := monebot.ImportBundle(ctx, db, b, pack, policy)
+		if err != nil {
+			log.Printf("Error importing bundle into '%s': %s\n", pack, err)
+			if err == monebot.ErrReadOnly {
+				return nil, WriteFailed(err)
+			}
+			ans.Text, ans.Parse = monebot.MessageInvalidBundle(err)
+			return
+		}
+
+		ans.Text, ans.Parse = monebot.MessageImportedBundle(result)
+
+	default:
+		ans.Text, ans.Parse = monebot.MessagePackUsage()
+	}
+
+	return
+}
+
+// downloadBundle fetches and reads the bundle in the telegram file
+func downloadBundle(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) (monebot.Bundle, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return monebot.Bundle{}, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return monebot.Bundle{}, err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return monebot.Bundle{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return monebot.Bundle{}, fmt.Errorf("downloading bundle: %s", resp.Status)
+	}
+
+	// Read one byte past the limit to tell if the bundle is too large
+	var content bytes.Buffer
+	if _, err := io.CopyN(&content, resp.Body, maxBundleSize+1); err != nil && err != io.EOF {
+		return monebot.Bundle{}, err
+	}
+	if content.Len() > maxBundleSize {
+		return monebot.Bundle{}, fmt.Errorf("bundle larger than %d bytes", maxBundleSize)
+	}
+
+	return monebot.ReadBundle(&content)
+}
+
+// chatPack returns the chat's pack, first giving the chat a pack of its own
+// if it has none
+func chatPack(ctx context.Context, db monebot.Store, chat int64) (string, error) {
+	pack, err := db.FindPack(ctx, chat)
+	if err != nil && err != monebot.ErrNotFound {
+		return "", err
+	}
+	if pack != "" {
+		return pack, nil
+	}
+
+	pack = chatPackName(chat)
+	return pack, db.AssignPack(ctx, chat, pack)
+}
+
+// chatPackName returns the name of the pack made for the chat, only with
+// characters allowed in commands
+func chatPackName(chat int64) string {
+	if chat < 0 {
+		return fmt.Sprintf("chat_g%d", -chat)
+	}
+	return fmt.Sprintf("chat_%d", chat)
+}
+
+// bundleName returns the pack name to be used in a file name
+func bundleName(pack string) string {
+	if pack == "" {
+		return "default"
+	}
+	return pack
+}
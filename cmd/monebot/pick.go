@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/monebot/util"
+)
+
+// Picks remembered per chat and command, and for how long
+const (
+	picksSize = 10000
+	picksTTL  = time.Hour
+)
+
+// Picker chooses among the alternatives of answers, remembering the last
+// choice of each command in each chat so it isn't repeated right away
+type Picker struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+	last *util.LRU
+}
+
+type pickKey struct {
+	chat    int64
+	command string
+}
+
+// NewPicker returns a picker with no choices remembered
+func NewPicker() *Picker {
+	return &Picker{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		last: util.NewLRU(picksSize, picksTTL),
+	}
+}
+
+// Pick returns one of the choices of the command's answer for the chat
+func (p *Picker) Pick(chat int64, c monebot.Command) monebot.Answer {
+	key := pickKey{chat, c.FullName()}
+	last := -1
+	if v, ok := p.last.Get(key); ok {
+		last = v.(int)
+	}
+
+	p.mu.Lock()
+	ans, picked := c.Answer.Pick(p.rand, last)
+	p.mu.Unlock()
+
+	p.last.Add(key, picked)
+	return ans
+}
+
+// Other returns a text choice of the answer other than text, or false if
+// there is none
+func (p *Picker) Other(a monebot.Answer, text string) (monebot.Answer, bool) {
+	texts := a.Texts()
+	if len(texts.Choices()) < 2 {
+		return monebot.Answer{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ans, _ := texts.Pick(p.rand, texts.Index(text))
+	return ans, true
+}
+
+// Intn returns a random number in [0, n)
+func (p *Picker) Intn(n int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Intn(n)
+}
+
+// Roll rolls the dice
+func (p *Picker) Roll(dice monebot.Dice) monebot.DiceRoll {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return dice.Roll(p.rand)
+}
+
+// picker is shared by all updates
+var picker = NewPicker()
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// pollTimeout is how long each long polling request waits for updates
+const pollTimeout = 30
+
+// PollUpdates long polls telegram for updates after offset, sending them to
+// the returned channel, which is closed once stop is closed. The channel is
+// unbuffered, so telegram is only told an update was received (by asking for
+// the ones after it) once it was taken from the channel
+func PollUpdates(bot *tgbotapi.BotAPI, offset int, stop <-chan struct{}) <-chan tgbotapi.Update {
+	updates := make(chan tgbotapi.Update)
+
+	go func() {
+		defer close(updates)
+
+		config := tgbotapi.NewUpdate(offset + 1)
+		config.Timeout = pollTimeout
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			received, err := bot.GetUpdates(config)
+			if err != nil {
+				log.Println("Failed to get updates, retrying in 3 seconds:", err)
+				select {
+				case <-stop:
+					return
+				case <-time.After(3 * time.Second):
+				}
+				continue
+			}
+
+			for _, update := range received {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					select {
+					case updates <- update:
+					case <-stop:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return updates
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/victormoneratto/monebot"
+)
+
+// defaultDice are rolled by /roll with no notation
+const defaultDice = "1d6"
+
+// Roll answers /roll [notation] with the total and every die rolled
+func Roll(param string) (ans monebot.Answer) {
+	if strings.TrimSpace(param) == "" {
+		param = defaultDice
+	}
+
+	dice, err := monebot.ParseDice(param)
+	if err != nil {
+		ans.Text, ans.Parse = monebot.MessageInvalidDice(err)
+		return
+	}
+
+	ans.Text, ans.Parse = monebot.MessageRoll(picker.Roll(dice))
+	return
+}
+
+// Flip answers /flip with heads or tails
+func Flip() (ans monebot.Answer) {
+	ans.Text, ans.Parse = monebot.MessageFlip(picker.Intn(2) == 0)
+	return
+}
+
+// Pick answers /pick a, b, c with one of the options
+func Pick(param string) (ans monebot.Answer) {
+	var options []string
+	for _, option := range strings.Split(param, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+
+	if len(options) == 0 {
+		ans.Text, ans.Parse = monebot.MessagePickUsage()
+		return
+	}
+
+	ans.Text, ans.Parse = monebot.MessagePick(options[picker.Intn(len(options))])
+	return
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// Limits of the reminders of each chat
+const (
+	maxReminders     = 100
+	maxReminderDelay = 366 * 24 * time.Hour
+)
+
+// RemindMe answers /remindme <when> <text>, reminding the sender
+func RemindMe(ctx context.Context, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, param string) monebot.Answer {
+	mention := strconv.Itoa(message.From.ID)
+	if message.From.UserName != "" {
+		mention = "@" + message.From.UserName
+	}
+	return addReminder(ctx, db, message, settings, mention, param)
+}
+
+// Remind answers /remind @user <when> <text>, reminding someone else
+func Remind(ctx context.Context, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, param string) (ans monebot.Answer) {
+	mention, rest := splitFirst(param)
+	if !strings.HasPrefix(mention, "@") || len(mention) < 2 {
+		ans.Text, ans.Parse = monebot.MessageRemindUsage()
+		return
+	}
+	return addReminder(ctx, db, message, settings, mention, rest)
+}
+
+// addReminder saves the reminder for the mentioned user, with the time and
+// text in param
+func addReminder(ctx context.Context, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, mention, param string) (ans monebot.Answer) {
+	now := time.Now().In(settings.Location())
+	due, text, err := monebot.ParseWhen(param, now)
+	if err != nil {
+		ans.Text, ans.Parse = monebot.MessageInvalidWhen(err)
+		return
+	}
+	if text == "" {
+		ans.Text, ans.Parse = monebot.MessageRemindUsage()
+		return
+	}
+	if due.Sub(now) > maxReminderDelay {
+		ans.Text, ans.Parse = monebot.MessageReminderTooFar()
+		return
+	}
+
+	reminders, err := db.ListReminders(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error listing reminders:", err)
+		return
+	}
+	if len(reminders) >= maxReminders {
+		ans.Text, ans.Parse = monebot.MessageTooManyReminders(maxReminders)
+		return
+	}
+
+	r := monebot.Reminder{Chat: message.Chat.ID, Mention: mention, Text: text, Due: due, Creator: message.From.String()}
+	if r, err = db.AddReminder(ctx, r); err != nil {
+		log.Println("Error adding reminder:", err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageReminderSaved(r, settings.Location())
+	return
+}
+
+// Reminders answers /reminders with the chat's reminders, numbered, and
+// /reminders cancel N by cancelling the Nth of them
+func Reminders(ctx context.Context, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, param string) (ans monebot.Answer) {
+	reminders, err := db.ListReminders(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error listing reminders:", err)
+		return
+	}
+
+	op, arg := splitFirst(param)
+	switch op {
+	case "":
+		ans.Text, ans.Parse = monebot.MessageReminders(reminders, settings.Location())
+		return
+
+	case "cancel":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(reminders) {
+			break
+		}
+
+		r := reminders[n-1]
+		if err := db.RemoveReminder(ctx, r.Chat, r.ID); err != nil && err != monebot.ErrNotFound {
+			log.Println("Error removing reminder:", err)
+			return WriteFailed(err)
+		}
+		ans.Text, ans.Parse = monebot.MessageReminderCancelled(r)
+		return
+	}
+
+	ans.Text, ans.Parse = monebot.MessageRemindersUsage()
+	return
+}
+
+// DeliverReminders posts the reminders that are due, removing each once it
+// is sent. A reminder that fails to be sent is tried again once its lease
+// expires, up to maxDeliveryAttempts times
+func DeliverReminders(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, now time.Time) {
+	due, err := db.DueReminders(ctx, now)
+	if err != nil {
+		if err != monebot.ErrReadOnly {
+			log.Println("Error finding due reminders:", err)
+		}
+		return
+	}
+
+	for _, r := range due {
+		// Leased first, so that only one attempt sends it at a time
+		r, err := db.ClaimReminder(ctx, r.ID, now, deliveryLease)
+		if err != nil {
+			if err != monebot.ErrNotFound {
+				log.Println("Error claiming reminder:", err)
+			}
+			continue
+		}
+
+		if r.Attempts > maxDeliveryAttempts {
+			log.Printf("Giving up on reminder %s in %d after %d attempts\n", r.ID.Hex(), r.Chat, r.Attempts-1)
+		} else {
+			msg := tgbotapi.NewMessage(r.Chat, "")
+			msg.Text, msg.ParseMode = monebot.MessageReminder(r)
+			if _, err := bot.Send(msg); err != nil {
+				log.Println("Error sending reminder:", err)
+				continue
+			}
+		}
+
+		if err := db.RemoveReminder(ctx, r.Chat, r.ID); err != nil && err != monebot.ErrNotFound {
+			log.Println("Error removing reminder:", err)
+		}
+	}
+}
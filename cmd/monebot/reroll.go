@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// rerollData is the callback data of the button asking for another answer
+const rerollData = "reroll"
+
+// rerollLimit is how many times each message can be rerolled
+const rerollLimit = 5
+
+// RerollCallback replaces the text of the message with another text of the
+// command it answered, removing the button once the limit is reached
+func RerollCallback(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, query *tgbotapi.CallbackQuery) {
+	answer := tgbotapi.NewCallback(query.ID, "")
+	defer func() {
+		if _, err := bot.AnswerCallbackQuery(answer); err != nil {
+			log.Println("Error answering callback:", err)
+		}
+	}()
+
+	if query.Message == nil {
+		return
+	}
+	chat, messageID := query.Message.Chat.ID, query.Message.MessageID
+
+	r, err := db.ClaimReroll(ctx, chat, messageID, rerollLimit)
+	if err == monebot.ErrNotFound {
+		answer.Text, _ = monebot.MessageNoMoreRerolls()
+		edit := tgbotapi.NewEditMessageReplyMarkup(chat, messageID, tgbotapi.NewInlineKeyboardMarkup())
+		if _, err := bot.Send(edit); err != nil {
+			log.Println("Error removing reroll button:", err)
+		}
+		return
+	} else if err != nil {
+		log.Println("Error claiming reroll:", err)
+		answer.Text = WriteFailed(err).Text
+		return
+	}
+
+	c, err := db.FindCommand(ctx, r.Command.Pack, r.Command.Name, len(r.Params))
+	if err != nil {
+		log.Printf("Error finding command %s: %s", r.Command.FullName(), err)
+		return
+	}
+
+	settings, err := db.FindSettings(ctx, chat)
+	if err != nil {
+		log.Println("Error finding settings:", err)
+	}
+
+	c.Answer = c.AnswerFor(chat, r.User, r.Time.In(settings.Location()))
+	ans, ok := picker.Other(c.Answer, r.Text)
+	if !ok {
+		answer.Text, _ = monebot.MessageNoMoreRerolls()
+		return
+	}
+	if ans.Parse == "" {
+		ans.Parse = settings.ParseMode
+	}
+
+	renderer := monebot.NewRenderer(db, chat)
+	renderer.Repeat = true
+	text, err := renderer.Render(ctx, c, ans.Text, r.Params)
+	if err != nil {
+		log.Printf("Error rendering command %s: %s", c.FullName(), err)
+		text, ans.Parse = monebot.MessageRenderFailed(err)
+	}
+
+	edit := tgbotapi.NewEditMessageText(chat, messageID, text)
+	edit.ParseMode = ans.Parse
+	if r.Count < rerollLimit {
+		keyboard := rerollKeyboard()
+		edit.ReplyMarkup = &keyboard
+	}
+	if _, err := bot.Send(edit); err != nil {
+		log.Println("Error rerolling message:", err)
+		return
+	}
+
+	if err := db.SaveRerollText(ctx, chat, messageID, ans.Text); err != nil {
+		log.Println("Error saving reroll:", err)
+	}
+}
+
+// rerollKeyboard returns the button asking for another answer
+func rerollKeyboard() tgbotapi.InlineKeyboardMarkup {
+	text, _ := monebot.MessageAnotherOne()
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, rerollData)))
+}
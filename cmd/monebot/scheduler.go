@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// schedulerInterval is how often due reminders and schedules are looked for
+const schedulerInterval = 15 * time.Second
+
+// schedulerLease is how long the process running the scheduler keeps it
+// without renewing, after which another one takes over
+const schedulerLease = time.Minute
+
+// A delivery holds what it posts for deliveryLease, after which a failed or
+// interrupted one is tried again, up to maxDeliveryAttempts times
+const (
+	deliveryLease       = time.Minute
+	maxDeliveryAttempts = 5
+)
+
+// RunScheduler posts everything scheduled once it is due, every
+// schedulerInterval, until stop is closed. Everything scheduled is kept in
+// the database, so what came due while the bot was down is posted on start.
+// Of all running bots, only the one holding the scheduler lease as owner
+// looks for what is due
+func RunScheduler(bot *tgbotapi.BotAPI, db monebot.Store, owner string, stop <-chan struct{}) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+		switch err := db.ClaimScheduler(ctx, owner, schedulerLease); err {
+		case nil:
+			DeliverReminders(ctx, bot, db, time.Now())
+			RunSchedules(ctx, bot, db, time.Now())
+		case monebot.ErrDuplicate, monebot.ErrReadOnly:
+		default:
+			log.Println("Error claiming scheduler:", err)
+		}
+		cancel()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
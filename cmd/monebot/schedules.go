@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// Limits of the schedules of each chat
+const (
+	maxSchedules        = 20
+	minScheduleInterval = 10 * time.Minute
+)
+
+// missedGrace is how late a run may be and still not count as missed
+const missedGrace = 5 * time.Minute
+
+// ScheduleCommand answers /schedule "<cron>" [pack.]name [skip|once], posting
+// the command at the times of the cron expression. Runs missed while the bot
+// is down are posted once, unless skip is given. In groups only admins can
+// schedule
+func ScheduleCommand(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, pack, param string) (ans monebot.Answer) {
+	spec, rest, ok := splitCron(param)
+	name, missed := splitFirst(rest)
+	if missed == "" {
+		missed = monebot.MissedOnce
+	}
+	if !ok || name == "" || missed != monebot.MissedOnce && missed != monebot.MissedSkip {
+		ans.Text, ans.Parse = monebot.MessageScheduleUsage()
+		return
+	}
+
+	if !CanChangeSettings(bot, message.Chat, message.From) {
+		ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+		return
+	}
+
+	cron, err := monebot.ParseCron(spec)
+	if err != nil {
+		ans.Text, ans.Parse = monebot.MessageInvalidCron(err)
+		return
+	}
+
+	next := cron.Next(time.Now().In(settings.Location()))
+	if next.IsZero() {
+		ans.Text, ans.Parse = monebot.MessageInvalidCron(fmt.Errorf("it never runs"))
+		return
+	}
+	for i, t := 0, next; i < 10; i++ {
+		after := cron.Next(t)
+		if !after.IsZero() && after.Sub(t) < minScheduleInterval {
+			ans.Text, ans.Parse = monebot.MessageInvalidCron(fmt.Errorf("it runs more than every %s", minScheduleInterval))
+			return
+		}
+		t = after
+	}
+
+	ref := commandRef(name, pack)
+	if _, err := db.FindCommand(ctx, ref.Pack, ref.Name, 0); err == monebot.ErrNotFound || err == monebot.ErrAliasCycle {
+		ans.Text, ans.Parse = monebot.MessageUnknownCommand(ref.Name)
+		return
+	} else if err != nil {
+		log.Printf("Error finding command %s: %s", ref.FullName(), err)
+		return
+	}
+
+	schedules, err := db.ListSchedules(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error listing schedules:", err)
+		return
+	}
+	if len(schedules) >= maxSchedules {
+		ans.Text, ans.Parse = monebot.MessageTooManySchedules(maxSchedules)
+		return
+	}
+
+	s := monebot.Schedule{Chat: message.Chat.ID, Cron: spec, Command: ref, Missed: missed, Next: next, Creator: message.From.String()}
+	if s, err = db.AddSchedule(ctx, s); err != nil {
+		log.Println("Error adding schedule:", err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageScheduleSaved(s, settings.Location())
+	return
+}
+
+// splitCron returns the cron expression at the start of s, either quoted or
+// as its first five words, and the rest of s
+func splitCron(s string) (spec, rest string, ok bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "\"") {
+		end := strings.IndexByte(s[1:], '"')
+		if end == -1 {
+			return "", "", false
+		}
+		return s[1 : end+1], strings.TrimSpace(s[end+2:]), true
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) < 5 {
+		return "", "", false
+	}
+	return strings.Join(fields[:5], " "), strings.Join(fields[5:], " "), true
+}
+
+// Schedules answers /schedules with the chat's schedules, numbered, and
+// /schedules cancel N by cancelling the Nth of them
+func Schedules(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, param string) (ans monebot.Answer) {
+	schedules, err := db.ListSchedules(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error listing schedules:", err)
+		return
+	}
+
+	op, arg := splitFirst(param)
+	switch op {
+	case "":
+		ans.Text, ans.Parse = monebot.MessageSchedules(schedules, settings.Location())
+		return
+
+	case "cancel":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(schedules) {
+			break
+		}
+		if !CanChangeSettings(bot, message.Chat, message.From) {
+			ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+			return
+		}
+
+		s := schedules[n-1]
+		if err := db.RemoveSchedule(ctx, s.Chat, s.ID); err != nil && err != monebot.ErrNotFound {
+			log.Println("Error removing schedule:", err)
+			return WriteFailed(err)
+		}
+		ans.Text, ans.Parse = monebot.MessageScheduleCancelled(s)
+		return
+	}
+
+	ans.Text, ans.Parse = monebot.MessageSchedulesUsage()
+	return
+}
+
+// RunSchedules posts the commands of the schedules that are due, moving
+// them to their next run once posted. A run that fails to be posted is tried
+// again once its lease expires, up to maxDeliveryAttempts times. Runs missed
+// by more than missedGrace are skipped if the schedule says so, and posted
+// once otherwise
+func RunSchedules(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, now time.Time) {
+	due, err := db.DueSchedules(ctx, now)
+	if err != nil {
+		if err != monebot.ErrReadOnly {
+			log.Println("Error finding due schedules:", err)
+		}
+		return
+	}
+
+	for _, s := range due {
+		settings, err := db.FindSettings(ctx, s.Chat)
+		if err != nil {
+			log.Println("Error finding settings:", err)
+			continue
+		}
+
+		var next time.Time
+		if cron, err := monebot.ParseCron(s.Cron); err == nil {
+			next = cron.Next(now.In(settings.Location()))
+		}
+		if next.IsZero() {
+			log.Printf("Removing schedule %s of %d, which never runs again\n", s.Cron, s.Chat)
+			if err := db.RemoveSchedule(ctx, s.Chat, s.ID); err != nil {
+				log.Println("Error removing schedule:", err)
+			}
+			continue
+		}
+
+		// Leased first, so that only one attempt posts the run at a time
+		s, err := db.ClaimSchedule(ctx, s.ID, s.Next, now, deliveryLease)
+		if err != nil {
+			if err != monebot.ErrNotFound {
+				log.Println("Error claiming schedule:", err)
+			}
+			continue
+		}
+
+		switch {
+		case s.Attempts > maxDeliveryAttempts:
+			log.Printf("Giving up on run of %s in %d after %d attempts\n", s.Command.FullName(), s.Chat, s.Attempts-1)
+		case now.Sub(s.Next) > missedGrace && s.Missed == monebot.MissedSkip:
+			log.Printf("Skipping missed run of %s in %d\n", s.Command.FullName(), s.Chat)
+		default:
+			if err := PostCommand(ctx, bot, db, s.Chat, settings, s.Command, now); err != nil {
+				log.Printf("Error posting command %s: %s", s.Command.FullName(), err)
+				continue
+			}
+		}
+
+		if err := db.AdvanceSchedule(ctx, s.ID, s.Next, next); err != nil && err != monebot.ErrNotFound {
+			log.Println("Error advancing schedule:", err)
+		}
+	}
+}
+
+// PostCommand posts the answer of the command in the chat, as if someone
+// had sent it with no parameters, returning why it couldn't be posted
+func PostCommand(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, chat int64, settings monebot.Settings, ref monebot.CommandRef, now time.Time) error {
+	c, err := db.FindCommand(ctx, ref.Pack, ref.Name, 0)
+	if err != nil {
+		return err
+	}
+
+	c.Answer = c.AnswerFor(chat, "", now.In(settings.Location()))
+	ans := picker.Pick(chat, c)
+
+	var send tgbotapi.Chattable
+	if ans.Sticker != "" {
+		send = tgbotapi.NewStickerShare(chat, ans.Sticker)
+	} else {
+		text, err := monebot.NewRenderer(db, chat).Render(ctx, c, ans.Text, nil)
+		if err != nil {
+			return err
+		}
+
+		msg := tgbotapi.NewMessage(chat, text)
+		msg.ParseMode = ans.Parse
+		if msg.ParseMode == "" {
+			msg.ParseMode = settings.ParseMode
+		}
+		send = msg
+	}
+
+	_, err = bot.Send(send)
+	return err
+}
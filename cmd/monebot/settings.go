@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// settingsPrefix starts the callback data of the /settings buttons
+const settingsPrefix = "settings:"
+
+// parseModes are cycled through by the parse mode button
+var parseModes = []string{"", monebot.ParseMarkdown, monebot.ParseHTML}
+
+// SettingsMenu answers /settings with the chat's settings as buttons
+func SettingsMenu(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message) (send tgbotapi.Chattable, ans monebot.Answer) {
+	if !CanChangeSettings(bot, message.Chat, message.From) {
+		ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+		return
+	}
+
+	settings, err := db.FindSettings(ctx, message.Chat.ID)
+	if err != nil {
+		log.Println("Error finding settings:", err)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "")
+	msg.Text, msg.ParseMode = monebot.MessageSettings()
+	msg.ReplyMarkup = settingsKeyboard(settings)
+	return msg, ans
+}
+
+// SettingsCallback changes the setting of the button pressed in the
+// /settings menu, updating the menu
+func SettingsCallback(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, query *tgbotapi.CallbackQuery) {
+	answer := tgbotapi.NewCallback(query.ID, "")
+	defer func() {
+		if _, err := bot.AnswerCallbackQuery(answer); err != nil {
+			log.Println("Error answering callback:", err)
+		}
+	}()
+
+	if query.Message == nil {
+		return
+	}
+	chat := query.Message.Chat
+
+	if !CanChangeSettings(bot, chat, query.From) {
+		answer.Text, _ = monebot.MessageAdminsOnly()
+		return
+	}
+
+	settings, err := db.FindSettings(ctx, chat.ID)
+	if err != nil {
+		log.Println("Error finding settings:", err)
+		return
+	}
+
+	switch strings.TrimPrefix(query.Data, settingsPrefix) {
+	case "forceReply":
+		settings.ForceReply = !settings.ForceReply
+	case "replyToReplied":
+		settings.ReplyToReplied = !settings.ReplyToReplied
+	case "silentUnknown":
+		settings.SilentUnknown = !settings.SilentUnknown
+	case "onlyAddressed":
+		settings.OnlyAddressed = !settings.OnlyAddressed
+	case "parseMode":
+		for i, mode := range parseModes {
+			if mode == settings.ParseMode {
+				settings.ParseMode = parseModes[(i+1)%len(parseModes)]
+				break
+			}
+		}
+	default:
+		return
+	}
+
+	if err := db.UpsertSettings(ctx, settings); err != nil {
+		log.Println("Error saving settings:", err)
+		answer.Text = WriteFailed(err).Text
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageReplyMarkup(chat.ID, query.Message.MessageID, settingsKeyboard(settings))
+	if _, err := bot.Send(edit); err != nil {
+		log.Println("Error updating settings menu:", err)
+	}
+}
+
+// CanChangeSettings reports whether the user may change the chat's
+// settings, which in groups only admins can
+func CanChangeSettings(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	if chat.IsPrivate() {
+		return true
+	}
+
+	member, err := bot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: user.ID})
+	if err != nil {
+		log.Println("Error finding chat member:", err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+func settingsKeyboard(s monebot.Settings) tgbotapi.InlineKeyboardMarkup {
+	parseMode := s.ParseMode
+	if parseMode == "" {
+		parseMode = "plain text"
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		settingsRow(check(s.ForceReply)+" Ask for replies", "forceReply"),
+		settingsRow(check(s.ReplyToReplied)+" Answer the replied message", "replyToReplied"),
+		settingsRow(check(s.SilentUnknown)+" Ignore unknown commands", "silentUnknown"),
+		settingsRow(check(s.OnlyAddressed)+" In groups, only answer /command@bot, mentions and replies", "onlyAddressed"),
+		settingsRow(fmt.Sprintf("Format answers as %s", parseMode), "parseMode"),
+	)
+}
+
+func settingsRow(text, key string) []tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(text, settingsPrefix+key))
+}
+
+func check(on bool) string {
+	if on {
+		return "✅"
+	}
+	return "⬜"
+}
+
+// Timezone answers /timezone with the chat's time zone, or /timezone Area/City
+// by setting it, which in groups only admins can
+func Timezone(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, settings monebot.Settings, param string) (ans monebot.Answer) {
+	name := strings.TrimSpace(param)
+	if name == "" {
+		ans.Text, ans.Parse = monebot.MessageTimezone(settings.Location())
+		return
+	}
+
+	if !CanChangeSettings(bot, message.Chat, message.From) {
+		ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+		return
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		ans.Text, ans.Parse = monebot.MessageInvalidTimezone(name)
+		return
+	}
+
+	settings.Timezone = loc.String()
+	if err := db.UpsertSettings(ctx, settings); err != nil {
+		log.Println("Error saving settings:", err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageTimezone(loc)
+	return
+}
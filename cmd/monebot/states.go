@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// stateTTL is how long the bot waits for the next message of a conversation
+const stateTTL = 10 * time.Minute
+
+// sweepInterval is how often expired states are looked for
+const sweepInterval = time.Minute
+
+// SweepStates removes expired states every sweepInterval, telling their
+// users that the conversation timed out, until stop is closed
+func SweepStates(bot *tgbotapi.BotAPI, db monebot.Store, stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+		expired, err := db.ExpireStates(ctx, time.Now().Add(-stateTTL))
+		cancel()
+		if err != nil {
+			if err != monebot.ErrReadOnly {
+				log.Println("Error expiring states:", err)
+			}
+			continue
+		}
+
+		for _, s := range expired {
+			log.Printf("State of %d in %d expired\n", s.User, s.Chat)
+			NotifyExpired(bot, s)
+		}
+	}
+}
+
+// NotifyExpired tells the user the conversation in the state timed out
+func NotifyExpired(bot *tgbotapi.BotAPI, s monebot.State) {
+	command := s.Flow
+	if f, ok := Flows[s.Flow]; ok {
+		command = f.Command
+	}
+
+	msg := tgbotapi.NewMessage(s.Chat, "")
+	msg.Text, msg.ParseMode = monebot.MessageStateExpired(s.User, stateTTL, command)
+	if _, err := bot.Send(msg); err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
+
+// Expired reports whether the conversation in the state timed out
+func Expired(s monebot.State) bool {
+	return time.Since(s.LastUpdate) > stateTTL
+}
+
+// Cancel forgets the conversation the user is having in the chat, if any
+func Cancel(ctx context.Context, db monebot.Store, message *tgbotapi.Message) (ans monebot.Answer) {
+	_, err := db.FindState(ctx, message.Chat.ID, message.From.ID)
+	if err == monebot.ErrNotFound {
+		ans.Text, ans.Parse = monebot.MessageNothingToCancel()
+		return
+	} else if err != nil {
+		log.Println("Error finding state:", err)
+		return
+	}
+
+	if err := db.RemoveState(ctx, message.Chat.ID, message.From.ID); err != nil {
+		log.Println("Error removing state:", err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageCancelled()
+	return
+}
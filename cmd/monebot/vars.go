@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// VarCommand answers /var set|get|reset [pack.]name [value], handling the
+// variables used in answers. Names without a pack belong to the chat, and
+// those of a pack can only be changed in the chats using it, which in groups
+// only admins can
+func VarCommand(ctx context.Context, bot *tgbotapi.BotAPI, db monebot.Store, message *tgbotapi.Message, param string) (ans monebot.Answer) {
+	op, rest := splitFirst(param)
+	arg, value := splitFirst(rest)
+	scope, name := monebot.VariableScope(message.Chat.ID, arg)
+	if name == "" {
+		op = ""
+	}
+
+	if op == "set" || op == "reset" {
+		ok, err := monebot.CanChangeScope(ctx, db, message.Chat.ID, scope)
+		if err != nil {
+			log.Printf("Error checking variable %s: %s", arg, err)
+			return
+		} else if !ok {
+			ans.Text, ans.Parse = monebot.MessageForeignPack()
+			return
+		}
+		if scope != monebot.ChatScope(message.Chat.ID) && !CanChangeSettings(bot, message.Chat, message.From) {
+			ans.Text, ans.Parse = monebot.MessageAdminsOnly()
+			return
+		}
+	}
+
+	switch op {
+	case "set":
+		if value == "" {
+			break
+		}
+
+		v := monebot.Variable{Scope: scope, Name: name, Value: value}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			v.Value, v.Count = "", n
+		}
+		if err := db.SetVariable(ctx, v); err != nil {
+			log.Printf("Error setting variable %s: %s", arg, err)
+			return WriteFailed(err)
+		}
+
+		ans.Text, ans.Parse = monebot.MessageVariable(arg, v)
+		return
+
+	case "get":
+		v, err := db.FindVariable(ctx, scope, name)
+		if err == monebot.ErrNotFound {
+			ans.Text, ans.Parse = monebot.MessageVariableUnset(arg)
+			return
+		} else if err != nil {
+			log.Printf("Error finding variable %s: %s", arg, err)
+			return
+		}
+
+		ans.Text, ans.Parse = monebot.MessageVariable(arg, v)
+		return
+
+	case "reset":
+		if err := db.RemoveVariable(ctx, scope, name); err != nil && err != monebot.ErrNotFound {
+			log.Printf("Error removing variable %s: %s", arg, err)
+			return WriteFailed(err)
+		}
+
+		ans.Text, ans.Parse = monebot.MessageVariableUnset(arg)
+		return
+	}
+
+	ans.Text, ans.Parse = monebot.MessageVarUsage()
+	return
+}
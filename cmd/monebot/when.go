@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/monebot/util"
+	"github.com/victormoneratto/telegram-bot-api"
+)
+
+// WhenCommand answers /when [pack.]name conditions content, adding to the
+// command a rule answering with the content when the conditions hold, or
+// /when [pack.]name clear, removing its rules. A command found in the
+// default pack is copied into the pack before adding the rule
+func WhenCommand(ctx context.Context, db monebot.Store, message *tgbotapi.Message, pack, param string) (ans monebot.Answer) {
+	name, rest := splitFirst(param)
+	conditions, content := splitFirst(rest)
+	if name == "" || conditions == "" {
+		ans.Text, ans.Parse = monebot.MessageWhenUsage()
+		return
+	}
+	ref := commandRef(name, pack)
+
+	if conditions == "clear" {
+		return clearRules(ctx, db, message, ref)
+	}
+	if content == "" {
+		ans.Text, ans.Parse = monebot.MessageWhenUsage()
+		return
+	}
+
+	r, err := monebot.ParseRule(conditions, message.Chat.ID)
+	if err != nil {
+		ans.Text, ans.Parse = monebot.MessageInvalidRule(err)
+		return
+	}
+	r.Answer = monebot.NewTextAnswer(content)
+
+	c, err := db.FindCommand(ctx, ref.Pack, ref.Name, r.Answer.NumParams)
+	if err == monebot.ErrNotFound || err == monebot.ErrAliasCycle {
+		ans.Text, ans.Parse = monebot.MessageUnknownCommand(ref.Name)
+		return
+	} else if err != nil {
+		log.Printf("Error finding command %s: %s", ref.FullName(), err)
+		return
+	}
+
+	if c.Pack != ref.Pack && len(c.Via) == 0 {
+		// A copy of the default pack's command, which other chats use
+		c.Pack, c.NumChanged = ref.Pack, 0
+	}
+
+	c.Rules = append(c.Rules, r)
+	c.Creator = message.From.String()
+	c.Time = time.Now()
+	c.NumChanged++
+	if err := db.UpsertCommand(ctx, c); err != nil {
+		log.Printf("Error saving command %s: %s", c.FullName(), err)
+		return WriteFailed(err)
+	}
+
+	ans.Text, ans.Parse = monebot.MessageSavedRule(c, r)
+	return
+}
+
+// clearRules removes the rules of every command with the name in the pack,
+// or with the name the alias resolves to, where WhenCommand adds them
+func clearRules(ctx context.Context, db monebot.Store, message *tgbotapi.Message, ref monebot.CommandRef) (ans monebot.Answer) {
+	found, err := db.FindCommand(ctx, ref.Pack, ref.Name, monebot.AnyParams)
+	if err == monebot.ErrNotFound || err == monebot.ErrAliasCycle {
+		ans.Text, ans.Parse = monebot.MessageUnknownCommand(ref.Name)
+		return
+	} else if err != nil {
+		log.Printf("Error finding command %s: %s", ref.FullName(), err)
+		return
+	}
+	target := ref
+	if len(found.Via) > 0 {
+		target = monebot.CommandRef{Pack: found.Pack, Name: util.NormalizeName(found.Name)}
+	}
+
+	commands, err := db.ListCommands(ctx, target.Pack)
+	if err != nil {
+		log.Println("Error listing commands:", err)
+		return
+	}
+
+	for _, c := range commands {
+		if c.Alias != nil || util.NormalizeName(c.Name) != target.Name || len(c.Rules) == 0 {
+			continue
+		}
+
+		c.Rules = nil
+		c.Creator = message.From.String()
+		c.Time = time.Now()
+		c.NumChanged++
+		if err := db.UpsertCommand(ctx, c); err != nil {
+			log.Printf("Error saving command %s: %s", c.FullName(), err)
+			return WriteFailed(err)
+		}
+	}
+
+	ans.Text, ans.Parse = monebot.MessageClearedRules(target.FullName())
+	return
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["backup"] = Subcommand{"Back up all collections, one after another, to a compressed archive", backup}
+	Subcommands["backup verify"] = Subcommand{"Verify the integrity of a backup archive", verifyBackup}
+	Offline["backup verify"] = true
+	Subcommands["restore"] = Subcommand{"Restore a backup archive into an empty database", restore}
+}
+
+func backup(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("o", "", "archive file to write")
+	parse(flags, args)
+	required(flags, "o")
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := db.Backup(ctx, f)
+	if err != nil {
+		os.Remove(*output)
+		return nil, err
+	}
+	return m, f.Close()
+}
+
+func verifyBackup(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("backup verify", flag.ExitOnError)
+	input := flags.String("i", "", "archive file to verify")
+	parse(flags, args)
+	required(flags, "i")
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return monebot.VerifyBackup(f)
+}
+
+func restore(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	input := flags.String("i", "", "archive file to restore")
+	parse(flags, args)
+	required(flags, "i")
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return db.Restore(ctx, f)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["packs export"] = Subcommand{"Export a pack as a JSON or YAML bundle", exportPack}
+	Subcommands["packs import"] = Subcommand{"Import a JSON or YAML bundle into a pack", importPack}
+}
+
+func exportPack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs export", flag.ExitOnError)
+	name := flags.String("name", "", "name of the pack, empty for the default pack")
+	history := flags.Bool("history", false, "include who last changed each command and when")
+	output := flags.String("o", "", "write the bundle to this file instead of printing it")
+	format := flags.String("format", monebot.FormatJSON, "format of the file ("+
+		monebot.FormatJSON+" or "+monebot.FormatYAML+")")
+	parse(flags, args)
+
+	if *format != monebot.FormatJSON && *format != monebot.FormatYAML {
+		return nil, fmt.Errorf("unknown format '%s'", *format)
+	}
+
+	b, err := monebot.ExportPack(ctx, db, *name, *history)
+	if err != nil || *output == "" {
+		return b, err
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := monebot.WriteBundle(f, b, *format); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"pack": b.Pack, "commands": len(b.Commands), "file": *output}, f.Close()
+}
+
+func importPack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs import", flag.ExitOnError)
+	input := flags.String("i", "", "bundle file to import, or - for stdin")
+	name := flags.String("name", "", "pack to import into, the bundle's pack if not given")
+	policy := flags.String("policy", monebot.ConflictSkip, "what to do with existing commands ("+
+		monebot.ConflictSkip+", "+monebot.ConflictOverwrite+" or "+monebot.ConflictRename+")")
+	parse(flags, args)
+	required(flags, "i")
+
+	f := os.Stdin
+	if *input != "-" {
+		var err error
+		if f, err = os.Open(*input); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	b, err := monebot.ReadBundle(f)
+	if err != nil {
+		return nil, err
+	}
+
+	pack := b.Pack
+	if *name != "" {
+		pack = *name
+	}
+
+	return monebot.ImportBundle(ctx, db, b, pack, *policy)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/monebot/util"
+)
+
+func init() {
+	Subcommands["commands list"] = Subcommand{"List commands, optionally of a pack", listCommands}
+	Subcommands["commands show"] = Subcommand{"Show a command", showCommand}
+	Subcommands["commands create"] = Subcommand{"Create or update a command", createCommand}
+	Subcommands["commands delete"] = Subcommand{"Delete a command", deleteCommand}
+}
+
+func listCommands(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("commands list", flag.ExitOnError)
+	pack := flags.String("pack", "", "only list commands of this pack")
+	defaultPack := flags.Bool("default", false, "only list commands of the default pack")
+	parse(flags, args)
+
+	var packs []string
+	if *pack != "" || *defaultPack {
+		packs = append(packs, *pack)
+	}
+
+	commands, err := db.ListCommands(ctx, packs...)
+	if commands == nil {
+		commands = []monebot.Command{}
+	}
+	return commands, err
+}
+
+func showCommand(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("commands show", flag.ExitOnError)
+	pack := flags.String("pack", "", "pack of the command, falling back to the default pack")
+	name := flags.String("name", "", "name of the command")
+	params := flags.Int("params", 0, "number of parameters of the command")
+	parse(flags, args)
+	required(flags, "name")
+
+	return db.FindCommand(ctx, *pack, *name, *params)
+}
+
+func createCommand(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("commands create", flag.ExitOnError)
+	pack := flags.String("pack", "", "pack of the command, empty for the default pack")
+	name := flags.String("name", "", "name of the command")
+	text := flags.String("text", "", "text answer, with %s verbs for parameters")
+	sticker := flags.String("sticker", "", "sticker file ID answer")
+	parseMode := flags.String("parse", "", "parse mode of the text answer ("+
+		monebot.ParseMarkdown+" or "+monebot.ParseHTML+")")
+	creator := flags.String("creator", "monebotctl", "creator of the command")
+	parse(flags, args)
+	required(flags, "name")
+
+	var ans monebot.Answer
+	switch {
+	case *text != "" && *sticker == "":
+		ans = monebot.NewTextAnswer(*text)
+		ans.Parse = *parseMode
+	case *sticker != "" && *text == "":
+		ans = monebot.NewStickerAnswer(*sticker)
+	default:
+		fmt.Fprintln(os.Stderr, "Exactly one of -text or -sticker is required")
+		os.Exit(2)
+	}
+
+	c := monebot.Command{Pack: *pack, Name: *name, Answer: ans, Creator: *creator, Time: time.Now()}
+	return c, db.UpsertCommand(ctx, c)
+}
+
+func deleteCommand(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("commands delete", flag.ExitOnError)
+	pack := flags.String("pack", "", "pack of the command, empty for the default pack")
+	name := flags.String("name", "", "name of the command")
+	params := flags.Int("params", 0, "number of parameters of the command")
+	verbatim := flags.Bool("verbatim", false, "match the name as stored, without normalizing it")
+	parse(flags, args)
+	required(flags, "name")
+
+	if !*verbatim {
+		*name = util.NormalizeName(*name)
+	}
+
+	err := db.RemoveCommand(ctx, *pack, *name, *params)
+	return map[string]interface{}{"pack": *pack, "name": *name, "numParams": *params}, err
+}
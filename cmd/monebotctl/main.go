@@ -0,0 +1,135 @@
+// monebotctl administrates the database used by monebot. Every subcommand
+// prints its result as JSON, e.g.
+//
+//	monebotctl commands list -pack memes
+//	monebotctl packs assign -name memes -chat -1001234
+//	monebotctl migrate -dry-run
+//	monebotctl repair -apply
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot"
+	"github.com/victormoneratto/monebot/util"
+)
+
+// timeout is how long a subcommand may take
+const timeout = 10 * time.Minute
+
+// Subcommand runs with the arguments after its name, returning the result to
+// be printed as JSON
+type Subcommand struct {
+	Usage string
+	Run   func(ctx context.Context, db *monebot.Database, args []string) (interface{}, error)
+}
+
+// Subcommands are keyed by their names, as typed in the command line
+var Subcommands = map[string]Subcommand{}
+
+// Offline holds the names of the subcommands that don't use the database,
+// which run without connecting to it, given a nil db
+var Offline = map[string]bool{}
+
+// Unindexed holds the names of the subcommands that run before the indexes
+// are ensured, e.g. to remove the duplicates that keep them from being created
+var Unindexed = map[string]bool{}
+
+func main() {
+	name, args := findSubcommand(os.Args[1:])
+	sub, ok := Subcommands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	var db *monebot.Database
+	if !Offline[name] {
+		dial := monebot.NewDatabase
+		if Unindexed[name] {
+			dial = monebot.DialDatabase
+		}
+
+		var err error
+		db, err = dial(util.MustGetenv("DATABASE_CONN_URI"))
+		if err != nil {
+			fail(err)
+		}
+		defer db.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result, err := sub.Run(ctx, db, args)
+	if err != nil {
+		fail(err)
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err := out.Encode(result); err != nil {
+		fail(err)
+	}
+}
+
+// findSubcommand returns the longest subcommand name matching the first
+// arguments, and the arguments after it
+func findSubcommand(args []string) (string, []string) {
+	for n := len(args); n > 0; n-- {
+		name := strings.Join(args[:n], " ")
+		if _, ok := Subcommands[name]; ok {
+			return name, args[n:]
+		}
+	}
+	return "", args
+}
+
+func usage() {
+	var names []string
+	for name := range Subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: monebotctl <subcommand> [flags]")
+	fmt.Fprintln(os.Stderr, "The database is given by DATABASE_CONN_URI")
+	fmt.Fprintln(os.Stderr)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name, Subcommands[name].Usage)
+	}
+}
+
+// fail prints the error as JSON to stderr and exits
+func fail(err error) {
+	json.NewEncoder(os.Stderr).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+	os.Exit(1)
+}
+
+// parse parses args into the flags, exiting with usage on error
+func parse(flags *flag.FlagSet, args []string) {
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Unexpected arguments:", flags.Args())
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// required fails if any of the named flags is empty or zero
+func required(flags *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		if v := flags.Lookup(name).Value.String(); v == "" || v == "0" {
+			fail(fmt.Errorf("-%s is required", name))
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["migrate"] = Subcommand{"Run pending database migrations", migrate}
+}
+
+func migrate(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only show what would be migrated")
+	parse(flags, args)
+
+	ran, err := db.Migrate(ctx, *dryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := db.SchemaVersion(ctx)
+	result := struct {
+		Version    int                 `json:"version"`
+		DryRun     bool                `json:"dryRun"`
+		Migrations []monebot.Migration `json:"migrations"`
+	}{version, *dryRun, ran}
+	if result.Migrations == nil {
+		result.Migrations = []monebot.Migration{}
+	}
+	return result, err
+}
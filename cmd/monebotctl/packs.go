@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["packs list"] = Subcommand{"List packs and their chats", listPacks}
+	Subcommands["packs create"] = Subcommand{"Create an empty pack", createPack}
+	Subcommands["packs delete"] = Subcommand{"Delete a pack and its commands", deletePack}
+	Subcommands["packs assign"] = Subcommand{"Make a pack the default of a chat", assignPack}
+	Subcommands["packs unassign"] = Subcommand{"Remove a chat from its default pack", unassignPack}
+}
+
+func listPacks(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	parse(flag.NewFlagSet("packs list", flag.ExitOnError), args)
+
+	packs, err := db.ListPacks(ctx)
+	if packs == nil {
+		packs = []monebot.Pack{}
+	}
+	return packs, err
+}
+
+func createPack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs create", flag.ExitOnError)
+	name := flags.String("name", "", "name of the pack")
+	parse(flags, args)
+	required(flags, "name")
+
+	return monebot.Pack{Name: *name}, db.CreatePack(ctx, *name)
+}
+
+func deletePack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs delete", flag.ExitOnError)
+	name := flags.String("name", "", "name of the pack")
+	parse(flags, args)
+	required(flags, "name")
+
+	return monebot.Pack{Name: *name}, db.RemovePack(ctx, *name)
+}
+
+func assignPack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs assign", flag.ExitOnError)
+	name := flags.String("name", "", "name of the pack, created if needed")
+	chat := flags.Int64("chat", 0, "ID of the chat")
+	parse(flags, args)
+	required(flags, "name", "chat")
+
+	return monebot.Pack{Name: *name, Chats: []int64{*chat}}, db.AssignPack(ctx, *chat, *name)
+}
+
+func unassignPack(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("packs unassign", flag.ExitOnError)
+	chat := flags.Int64("chat", 0, "ID of the chat")
+	parse(flags, args)
+	required(flags, "chat")
+
+	return map[string]int64{"chat": *chat}, db.UnassignPack(ctx, *chat)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["repair"] = Subcommand{"Find duplicates that block the unique indexes, removing them with -apply", repair}
+	Unindexed["repair"] = true
+}
+
+func repair(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	apply := flags.Bool("apply", false, "remove or merge the duplicates found and ensure the indexes")
+	parse(flags, args)
+
+	repairs, err := db.Repair(ctx, !*apply)
+	if err == nil && *apply {
+		err = db.EnsureIndexes(ctx)
+	}
+
+	result := struct {
+		Applied bool             `json:"applied"`
+		Repairs []monebot.Repair `json:"repairs"`
+	}{*apply, repairs}
+	if result.Repairs == nil {
+		result.Repairs = []monebot.Repair{}
+	}
+	return result, err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/victormoneratto/monebot"
+)
+
+func init() {
+	Subcommands["states list"] = Subcommand{"List pending conversation states", listStates}
+	Subcommands["states clear"] = Subcommand{"Clear the state of a user, or of everyone", clearStates}
+}
+
+func listStates(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	parse(flag.NewFlagSet("states list", flag.ExitOnError), args)
+
+	states, err := db.ListStates(ctx)
+	if states == nil {
+		states = []monebot.State{}
+	}
+	return states, err
+}
+
+func clearStates(ctx context.Context, db *monebot.Database, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet("states clear", flag.ExitOnError)
+	chat := flags.Int64("chat", 0, "ID of the chat, clears all states if not given")
+	user := flags.Int("user", 0, "ID of the user in the chat")
+	parse(flags, args)
+
+	if *chat == 0 {
+		removed, err := db.ClearStates(ctx)
+		return map[string]int{"removed": removed}, err
+	}
+
+	required(flags, "user")
+	return map[string]int{"removed": 1}, db.RemoveState(ctx, *chat, *user)
+}
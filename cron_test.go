@@ -0,0 +1,70 @@
+package monebot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Monday
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"* * * * *":          time.Date(2026, 10, 19, 14, 31, 0, 0, time.UTC),
+		"*/15 * * * *":       time.Date(2026, 10, 19, 14, 45, 0, 0, time.UTC),
+		"0 9 * * MON":        time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC),
+		"0 9 * * 1-5":        time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC),
+		"30 14 * * *":        time.Date(2026, 10, 20, 14, 30, 0, 0, time.UTC),
+		"0 0 1 jan *":        time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		"0 12 13 * fri":      time.Date(2026, 10, 23, 12, 0, 0, 0, time.UTC),
+		"0 12 31 2 *":        {},
+		"0 8 * * 7":          time.Date(2026, 10, 25, 8, 0, 0, 0, time.UTC),
+		"5,10 10-11/1 * * *": time.Date(2026, 10, 20, 10, 5, 0, 0, time.UTC),
+	}
+	for spec, want := range cases {
+		c, err := ParseCron(spec)
+		if err != nil {
+			t.Errorf("ParseCron(%s): unexpected error %s", spec, err)
+			continue
+		}
+		if got := c.Next(now); !got.Equal(want) {
+			t.Errorf("Next of %s = %s, expected %s", spec, got, want)
+		}
+	}
+}
+
+func TestCronNextLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-3", -3*60*60)
+	c, _ := ParseCron("0 9 * * *")
+
+	next := c.Next(time.Date(2026, 10, 19, 10, 0, 0, 0, loc))
+	if want := time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, next)
+	}
+}
+
+func TestCronNextFallBack(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("No time zone database:", err)
+	}
+	c, _ := ParseCron("30 1 * * *")
+
+	// Clocks fall back from 2:00 EDT to 1:00 EST on 2026-11-01
+	first := c.Next(time.Date(2026, 11, 1, 0, 0, 0, 0, loc))
+	if want := time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC); !first.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, first)
+	}
+	if next := c.Next(first); !next.Equal(time.Date(2026, 11, 2, 6, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected the next day, got %s", next)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "a b c d e"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%s): expected error", spec)
+		}
+	}
+}
@@ -1,28 +1,69 @@
 package monebot
 
 import (
+	"context"
 	"errors"
+	"time"
+
+	"github.com/victormoneratto/monebot/util"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 var (
-	ErrNotFound = errors.New("Not found")
+	ErrNotFound   = errors.New("Not found")
+	ErrDuplicate  = errors.New("Duplicate")
+	ErrAliasCycle = errors.New("Alias cycle or chain too long")
 )
 
-// Database holds the necessary data for all persistent data operations
+// maxAliasDepth is how many aliases FindCommand follows
+const maxAliasDepth = 8
+
+// dialTimeout is how long NewDatabase waits for the first connection
+const dialTimeout = 10 * time.Second
+
+// Database holds the necessary data for all persistent data operations.
+// Every operation runs on its own copy of the session, so a dropped socket
+// only affects the operations using it
 type Database struct {
-	session  *mgo.Session
-	commands *mgo.Collection
-	packs    *mgo.Collection
-	states   *mgo.Collection
+	session   *mgo.Session
+	commands  *mgo.Collection
+	packs     *mgo.Collection
+	states    *mgo.Collection
+	updates   *mgo.Collection
+	meta      *mgo.Collection
+	settings  *mgo.Collection
+	rerolls   *mgo.Collection
+	vars      *mgo.Collection
+	reminders *mgo.Collection
+	schedules *mgo.Collection
+
+	health *healthMonitor
 }
 
-// NewDatabase returns a new database connected through the connURI
+// NewDatabase returns a new database connected through the connURI, with
+// every index ensured
 func NewDatabase(connURI string) (*Database, error) {
+	db, err := DialDatabase(connURI)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	if err = db.EnsureIndexes(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// DialDatabase returns a new database connected through the connURI, without
+// ensuring the indexes, e.g. to Repair what keeps them from being created
+func DialDatabase(connURI string) (*Database, error) {
 	var db Database
 	var err error
-	db.session, err = mgo.Dial(connURI)
+	db.session, err = mgo.DialWithTimeout(connURI, dialTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -30,82 +71,621 @@ func NewDatabase(connURI string) (*Database, error) {
 	db.commands = db.session.DB("").C("commands")
 	db.packs = db.session.DB("").C("packs")
 	db.states = db.session.DB("").C("states")
+	db.updates = db.session.DB("").C("updates")
+	db.meta = db.session.DB("").C("meta")
+	db.settings = db.session.DB("").C("settings")
+	db.rerolls = db.session.DB("").C("rerolls")
+	db.vars = db.session.DB("").C("vars")
+	db.reminders = db.session.DB("").C("reminders")
+	db.schedules = db.session.DB("").C("schedules")
+
+	db.health = newHealthMonitor(db.session)
 
 	return &db, nil
 }
 
 // Close the database session
-func (db Database) Close() {
+func (db *Database) Close() {
+	db.health.Stop()
 	db.session.Close()
 }
 
+// Health returns the result of the last health check
+func (db *Database) Health() Health {
+	return db.health.Health()
+}
+
+// with runs f with a copy of the session, unless ctx is already done.
+// An operation can't be abandoned without racing on what f decodes into,
+// so f always runs to the end: the socket timeout follows ctx's deadline,
+// and a failure after ctx is done returns ctx's error
+func (db *Database) with(ctx context.Context, f func(s *mgo.Session) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s := db.session.Copy()
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		// A zero timeout would mean no timeout at all
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		s.SetSocketTimeout(timeout)
+	}
+
+	err := f(s)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == mgo.ErrNotFound {
+		err = ErrNotFound
+	}
+	return err
+}
+
 // FindPack returns the default pack name for the chat
-func (db Database) FindPack(chat int64) (string, error) {
+func (db *Database) FindPack(ctx context.Context, chat int64) (string, error) {
 	var pack Pack
-	err := db.packs.Find(bson.M{"chats": chat}).One(&pack)
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.packs.With(s).Find(bson.M{"chats": chat}).One(&pack)
+	})
 	if err != nil {
-		if err == mgo.ErrNotFound {
-			err = ErrNotFound
-		}
 		return "", err
 	}
 	return pack.Name, nil
 }
 
-// FindCommand returns the one command filtered by the pack, name and numParams,
-// or an error if not found
-func (db Database) FindCommand(pack, name string, numParams int) (Command, error) {
-	var c Command
+// AssignPack makes pack the default pack for the chat, creating the pack
+// if needed and removing the chat from its previous pack
+func (db *Database) AssignPack(ctx context.Context, chat int64, pack string) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		packs := db.packs.With(s)
+		_, err := packs.UpdateAll(bson.M{"chats": chat, "name": bson.M{"$ne": pack}},
+			bson.M{"$pull": bson.M{"chats": chat}})
+		if err == nil {
+			err = unsetEmptyChats(packs)
+		}
+		if err != nil {
+			return err
+		}
 
-	// Filter by name, numParams and packName, sort by pack descending and get the
-	// first element (from specified pack first, if it exists, or from default pack)
-	err := db.commands.Find(
-		bson.M{"name": name,
-			"answer.numParams": numParams,
-			"$or": []bson.M{
-				bson.M{"pack": pack},
-				bson.M{"pack": ""},
-			}}).Sort("-pack").One(&c)
+		_, err = packs.Upsert(bson.M{"name": pack},
+			bson.M{"$addToSet": bson.M{"chats": chat}})
+		return err
+	})
+}
 
-	if err != nil {
-		if err == mgo.ErrNotFound {
-			err = ErrNotFound
+// UnassignPack removes the chat from its default pack, if any
+func (db *Database) UnassignPack(ctx context.Context, chat int64) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		packs := db.packs.With(s)
+		_, err := packs.UpdateAll(bson.M{"chats": chat},
+			bson.M{"$pull": bson.M{"chats": chat}})
+		if err != nil {
+			return err
+		}
+		return unsetEmptyChats(packs)
+	})
+}
+
+// unsetEmptyChats removes the chats of packs left with none, as the unique
+// index on chats counts an empty array as a value
+func unsetEmptyChats(packs *mgo.Collection) error {
+	_, err := packs.UpdateAll(bson.M{"chats": bson.M{"$size": 0}},
+		bson.M{"$unset": bson.M{"chats": 1}})
+	return err
+}
+
+// ListPacks returns all packs sorted by name
+func (db *Database) ListPacks(ctx context.Context) ([]Pack, error) {
+	var packs []Pack
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.packs.With(s).Find(nil).Sort("name").All(&packs)
+	})
+	return packs, err
+}
+
+// CreatePack creates an empty pack, or returns ErrDuplicate if it exists
+func (db *Database) CreatePack(ctx context.Context, name string) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		err := db.packs.With(s).Insert(Pack{Name: name})
+		if mgo.IsDup(err) {
+			err = ErrDuplicate
+		}
+		return err
+	})
+}
+
+// RemovePack removes the pack along with all its commands
+func (db *Database) RemovePack(ctx context.Context, name string) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		if err := db.packs.With(s).Remove(bson.M{"name": name}); err != nil {
+			return err
+		}
+		_, err := db.commands.With(s).RemoveAll(bson.M{"pack": name})
+		return err
+	})
+}
+
+// FindCommand returns the one command filtered by the pack, name and numParams,
+// or an error if not found. The name is matched normalized, and aliases are
+// followed to the command they resolve to
+func (db *Database) FindCommand(ctx context.Context, pack, name string, numParams int) (Command, error) {
+	var via []Command
+	for {
+		c, err := db.findCommand(ctx, pack, name, numParams)
+		if err != nil || c.Alias == nil {
+			c.Via = via
+			return c, err
+		}
+
+		for _, alias := range via {
+			if alias.FullName() == c.FullName() {
+				return c, ErrAliasCycle
+			}
 		}
-		return c, err
+		if len(via) == maxAliasDepth {
+			return c, ErrAliasCycle
+		}
+
+		via = append(via, c)
+		pack, name = c.Alias.Pack, c.Alias.Name
 	}
+}
+
+// findCommand returns the one command or alias filtered by the pack, name and
+// numParams
+func (db *Database) findCommand(ctx context.Context, pack, name string, numParams int) (Command, error) {
+	var c Command
+	name = util.NormalizeName(name)
 
-	return c, nil
+	query := bson.M{"name": name,
+		"$or": []bson.M{
+			bson.M{"pack": pack},
+			bson.M{"pack": ""},
+		}}
+	if numParams != AnyParams {
+		query["answer.numParams"] = bson.M{"$in": []int{numParams, AnyParams}}
+	}
+
+	// Sort by pack descending and get the first element (from specified pack
+	// first, if it exists, or from default pack), preferring commands to
+	// aliases
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.commands.With(s).Find(query).Sort("-pack", "-answer.numParams").One(&c)
+	})
+
+	return c, err
 }
 
-// UpsertCommand updates or inserts the given command
-func (db Database) UpsertCommand(c Command) error {
-	_, err := db.commands.Upsert(
-		bson.M{"pack": c.Pack,
-			"name":             c.Name,
-			"answer.numParams": c.Answer.NumParams}, &c)
+// UpsertCommand updates or inserts the given command, normalizing its name
+func (db *Database) UpsertCommand(ctx context.Context, c Command) error {
+	c.Name = util.NormalizeName(c.Name)
+	return db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.commands.With(s).Upsert(
+			bson.M{"pack": c.Pack,
+				"name":             c.Name,
+				"answer.numParams": c.Answer.NumParams}, &c)
+		return err
+	})
+}
 
-	return err
+// ListCommands returns the commands in the given packs, or all commands if
+// no pack is given, sorted by pack and name
+func (db *Database) ListCommands(ctx context.Context, packs ...string) ([]Command, error) {
+	var query bson.M
+	if len(packs) > 0 {
+		query = bson.M{"pack": bson.M{"$in": packs}}
+	}
+
+	var commands []Command
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.commands.With(s).Find(query).
+			Sort("pack", "name", "answer.numParams").All(&commands)
+	})
+	return commands, err
+}
+
+// RemoveCommand removes the command from the pack, ignoring the default pack.
+// The name is matched verbatim, so that commands left with names colliding
+// after normalization can still be removed
+func (db *Database) RemoveCommand(ctx context.Context, pack, name string, numParams int) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.commands.With(s).Remove(
+			bson.M{"pack": pack,
+				"name":             name,
+				"answer.numParams": numParams})
+	})
 }
 
-func (db Database) FindState(chat int64, user int) (State, error) {
+func (db *Database) FindState(ctx context.Context, chat int64, user int) (State, error) {
 	var s State
-	err := db.states.Find(
-		bson.M{"chat": chat,
-			"user": user}).One(&s)
-	if err == mgo.ErrNotFound {
-		err = ErrNotFound
-	}
+	err := db.with(ctx, func(session *mgo.Session) error {
+		return db.states.With(session).Find(
+			bson.M{"chat": chat,
+				"user": user}).One(&s)
+	})
 	return s, err
 }
 
-func (db Database) UpsertState(s State) error {
-	_, err := db.states.Upsert(
-		bson.M{"chat": s.Chat,
-			"user": s.User}, &s)
+func (db *Database) UpsertState(ctx context.Context, s State) error {
+	return db.with(ctx, func(session *mgo.Session) error {
+		_, err := db.states.With(session).Upsert(
+			bson.M{"chat": s.Chat,
+				"user": s.User}, &s)
+		return err
+	})
+}
 
-	return err
+func (db *Database) RemoveState(ctx context.Context, chat int64, user int) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.states.With(s).Remove(bson.M{"chat": chat, "user": user})
+	})
+}
+
+// ExpireStates removes the states last updated before the given time,
+// returning the removed ones. A state updated meanwhile isn't removed
+func (db *Database) ExpireStates(ctx context.Context, before time.Time) ([]State, error) {
+	var expired []State
+	err := db.with(ctx, func(s *mgo.Session) error {
+		states := db.states.With(s)
+
+		var candidates []State
+		err := states.Find(bson.M{"lastUpdate": bson.M{"$lt": before}}).All(&candidates)
+		if err != nil {
+			return err
+		}
+
+		for _, state := range candidates {
+			err := states.Remove(bson.M{"chat": state.Chat, "user": state.User,
+				"lastUpdate": state.LastUpdate})
+			if err == mgo.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			expired = append(expired, state)
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// ListStates returns the states of all users in all chats
+func (db *Database) ListStates(ctx context.Context) ([]State, error) {
+	var states []State
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.states.With(s).Find(nil).Sort("chat", "user").All(&states)
+	})
+	return states, err
+}
+
+// ClearStates removes the states of all users in all chats, returning how
+// many were removed
+func (db *Database) ClearStates(ctx context.Context) (int, error) {
+	var removed int
+	err := db.with(ctx, func(s *mgo.Session) error {
+		info, err := db.states.With(s).RemoveAll(nil)
+		if err == nil {
+			removed = info.Removed
+		}
+		return err
+	})
+	return removed, err
+}
+
+// FindSettings returns the settings of the chat, or the defaults if the chat
+// never changed them
+func (db *Database) FindSettings(ctx context.Context, chat int64) (Settings, error) {
+	settings := DefaultSettings(chat)
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.settings.With(s).Find(bson.M{"chat": chat}).One(&settings)
+	})
+	if err == ErrNotFound {
+		err = nil
+	}
+	return settings, err
+}
+
+// UpsertSettings updates or inserts the settings of a chat
+func (db *Database) UpsertSettings(ctx context.Context, settings Settings) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.settings.With(s).Upsert(bson.M{"chat": settings.Chat}, &settings)
+		return err
+	})
+}
+
+// FindOffset returns the ID of the last fully processed update, or 0 if no
+// update was processed yet
+func (db *Database) FindOffset(ctx context.Context) (int, error) {
+	var m struct {
+		UpdateID int `bson:"updateID"`
+	}
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.meta.With(s).FindId("offset").One(&m)
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return m.UpdateID, err
+}
+
+// SaveOffset stores the ID of the last fully processed update. The stored
+// value never goes backwards, even if offsets are saved out of order
+func (db *Database) SaveOffset(ctx context.Context, updateID int) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.meta.With(s).UpsertId("offset",
+			bson.M{"$max": bson.M{"updateID": updateID}})
+		return err
+	})
+}
+
+// ClaimUpdate leases the update to owner for the given duration, returning
+// ErrDuplicate if it was already fully processed or is leased to someone
+// else. An update claimed but never finished (e.g. the bot crashed while
+// processing it) can only be claimed again once its lease expires. Owner can
+// always claim it again, e.g. when retrying a claim that timed out after
+// reaching the database
+func (db *Database) ClaimUpdate(ctx context.Context, updateID int, owner string, lease time.Duration) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		updates := db.updates.With(s)
+		now := time.Now()
+		err := updates.Insert(bson.M{"_id": updateID, "done": false, "owner": owner,
+			"until": now.Add(lease), "time": now})
+		if !mgo.IsDup(err) {
+			return err
+		}
+
+		// Renew our own lease, or take over an expired one, only if nobody
+		// else did it first
+		err = updates.Update(
+			bson.M{"_id": updateID,
+				"done": false,
+				"$or": []bson.M{
+					bson.M{"owner": owner},
+					bson.M{"until": bson.M{"$lt": now}},
+				}},
+			bson.M{"$set": bson.M{"owner": owner, "until": now.Add(lease)}})
+		if err == mgo.ErrNotFound {
+			err = ErrDuplicate
+		}
+		return err
+	})
+}
+
+// ClaimScheduler makes owner the one process running the scheduler for the
+// given duration, renewing its own claim, and returns ErrDuplicate while
+// another owner holds it
+func (db *Database) ClaimScheduler(ctx context.Context, owner string, lease time.Duration) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		now := time.Now()
+		_, err := db.meta.With(s).Upsert(
+			bson.M{"_id": "scheduler",
+				"$or": []bson.M{
+					bson.M{"owner": owner},
+					bson.M{"until": bson.M{"$lt": now}},
+				}},
+			bson.M{"$set": bson.M{"owner": owner, "until": now.Add(lease)}})
+		if mgo.IsDup(err) {
+			// Held by someone else, so the upsert tried to insert it again
+			err = ErrDuplicate
+		}
+		return err
+	})
+}
+
+// FinishUpdate marks the update as fully processed
+func (db *Database) FinishUpdate(ctx context.Context, updateID int) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.updates.With(s).UpdateId(updateID, bson.M{"$set": bson.M{"done": true}})
+	})
+}
+
+// SaveReroll updates or inserts the reroll of the message
+func (db *Database) SaveReroll(ctx context.Context, r Reroll) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.rerolls.With(s).Upsert(
+			bson.M{"chat": r.Chat,
+				"message": r.Message}, &r)
+		return err
+	})
+}
+
+// ClaimReroll counts one more reroll of the message, returning it, or
+// ErrNotFound if there is none or it was already rerolled limit times
+func (db *Database) ClaimReroll(ctx context.Context, chat int64, message, limit int) (Reroll, error) {
+	var r Reroll
+	err := db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.rerolls.With(s).Find(
+			bson.M{"chat": chat,
+				"message": message,
+				"count":   bson.M{"$lt": limit}}).
+			Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"count": 1}}, ReturnNew: true}, &r)
+		return err
+	})
+	return r, err
+}
+
+// SaveRerollText records the text the message was rerolled to, leaving the
+// count to ClaimReroll
+func (db *Database) SaveRerollText(ctx context.Context, chat int64, message int, text string) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.rerolls.With(s).Update(
+			bson.M{"chat": chat,
+				"message": message},
+			bson.M{"$set": bson.M{"text": text}})
+	})
+}
+
+// FindVariable returns the variable of the scope
+func (db *Database) FindVariable(ctx context.Context, scope, name string) (Variable, error) {
+	var v Variable
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.vars.With(s).Find(
+			bson.M{"scope": scope,
+				"name": name}).One(&v)
+	})
+	return v, err
+}
+
+// SetVariable updates or inserts the variable
+func (db *Database) SetVariable(ctx context.Context, v Variable) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.vars.With(s).Upsert(
+			bson.M{"scope": v.Scope,
+				"name": v.Name}, &v)
+		return err
+	})
+}
+
+// IncrementCounter atomically adds delta to the counter of the scope,
+// starting from zero, and returns its new count. The text of the variable is
+// cleared, so it shows the count again
+func (db *Database) IncrementCounter(ctx context.Context, scope, name string, delta int64) (int64, error) {
+	var v Variable
+	err := db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.vars.With(s).Find(
+			bson.M{"scope": scope,
+				"name": name}).
+			Apply(mgo.Change{Update: bson.M{"$inc": bson.M{"count": delta}, "$unset": bson.M{"value": 1}}, Upsert: true, ReturnNew: true}, &v)
+		return err
+	})
+	return v.Count, err
+}
+
+// RemoveVariable removes the variable of the scope
+func (db *Database) RemoveVariable(ctx context.Context, scope, name string) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.vars.With(s).Remove(
+			bson.M{"scope": scope,
+				"name": name})
+	})
+}
+
+// AddReminder inserts the reminder, returning it with its ID
+func (db *Database) AddReminder(ctx context.Context, r Reminder) (Reminder, error) {
+	r.ID = bson.NewObjectId()
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.reminders.With(s).Insert(&r)
+	})
+	return r, err
+}
+
+// ListReminders returns the reminders of the chat sorted by due time
+func (db *Database) ListReminders(ctx context.Context, chat int64) ([]Reminder, error) {
+	var reminders []Reminder
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.reminders.With(s).Find(bson.M{"chat": chat}).Sort("due", "_id").All(&reminders)
+	})
+	return reminders, err
+}
+
+// DueReminders returns the reminders due before the time, oldest first
+func (db *Database) DueReminders(ctx context.Context, before time.Time) ([]Reminder, error) {
+	var reminders []Reminder
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.reminders.With(s).Find(bson.M{"due": bson.M{"$lte": before}}).Sort("due").All(&reminders)
+	})
+	return reminders, err
+}
+
+// ClaimReminder leases the reminder for a delivery attempt until now plus
+// lease, counting the attempt. It returns ErrNotFound if the reminder is gone
+// or another attempt holds it
+func (db *Database) ClaimReminder(ctx context.Context, id bson.ObjectId, now time.Time, lease time.Duration) (Reminder, error) {
+	var r Reminder
+	err := db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.reminders.With(s).Find(
+			bson.M{"_id": id,
+				"$or": []bson.M{
+					bson.M{"leased": bson.M{"$exists": false}},
+					bson.M{"leased": bson.M{"$lte": now}},
+				}}).
+			Apply(mgo.Change{
+				Update: bson.M{
+					"$set": bson.M{"leased": now.Add(lease)},
+					"$inc": bson.M{"attempts": 1}},
+				ReturnNew: true}, &r)
+		return err
+	})
+	return r, err
+}
+
+// RemoveReminder removes the reminder of the chat
+func (db *Database) RemoveReminder(ctx context.Context, chat int64, id bson.ObjectId) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.reminders.With(s).Remove(bson.M{"_id": id, "chat": chat})
+	})
+}
+
+// AddSchedule inserts the schedule, returning it with its ID
+func (db *Database) AddSchedule(ctx context.Context, sch Schedule) (Schedule, error) {
+	sch.ID = bson.NewObjectId()
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.schedules.With(s).Insert(&sch)
+	})
+	return sch, err
+}
+
+// ListSchedules returns the schedules of the chat sorted by next run
+func (db *Database) ListSchedules(ctx context.Context, chat int64) ([]Schedule, error) {
+	var schedules []Schedule
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.schedules.With(s).Find(bson.M{"chat": chat}).Sort("next", "_id").All(&schedules)
+	})
+	return schedules, err
+}
+
+// DueSchedules returns the schedules whose next run is before the time
+func (db *Database) DueSchedules(ctx context.Context, before time.Time) ([]Schedule, error) {
+	var schedules []Schedule
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.schedules.With(s).Find(bson.M{"next": bson.M{"$lte": before}}).Sort("next").All(&schedules)
+	})
+	return schedules, err
+}
+
+// ClaimSchedule leases the run of the schedule at from for an attempt to
+// post it until now plus lease, counting the attempt. It returns ErrNotFound
+// if the schedule is gone, was advanced or another attempt holds it
+func (db *Database) ClaimSchedule(ctx context.Context, id bson.ObjectId, from, now time.Time, lease time.Duration) (Schedule, error) {
+	var sch Schedule
+	err := db.with(ctx, func(s *mgo.Session) error {
+		_, err := db.schedules.With(s).Find(
+			bson.M{"_id": id,
+				"next": from,
+				"$or": []bson.M{
+					bson.M{"leased": bson.M{"$exists": false}},
+					bson.M{"leased": bson.M{"$lte": now}},
+				}}).
+			Apply(mgo.Change{
+				Update: bson.M{
+					"$set": bson.M{"leased": now.Add(lease)},
+					"$inc": bson.M{"attempts": 1}},
+				ReturnNew: true}, &sch)
+		return err
+	})
+	return sch, err
+}
+
+// AdvanceSchedule moves the next run of the schedule from one time to
+// another, releasing its lease. It returns ErrNotFound if it isn't at from
+// anymore, e.g. when another run already advanced it
+func (db *Database) AdvanceSchedule(ctx context.Context, id bson.ObjectId, from, to time.Time) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.schedules.With(s).Update(
+			bson.M{"_id": id,
+				"next": from},
+			bson.M{"$set": bson.M{"next": to},
+				"$unset": bson.M{"leased": 1, "attempts": 1}})
+	})
 }
 
-func (db Database) RemoveState(chat int64, user int) error {
-	return db.states.Remove(bson.M{"chat": chat, "user": user})
+// RemoveSchedule removes the schedule of the chat
+func (db *Database) RemoveSchedule(ctx context.Context, chat int64, id bson.ObjectId) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		return db.schedules.With(s).Remove(bson.M{"_id": id, "chat": chat})
+	})
 }
@@ -0,0 +1,66 @@
+package monebot
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabase returns a database for tests that need one, connected through
+// TEST_DATABASE_CONN_URI, skipping the test if it is empty. The collections
+// used by the tests are dropped first
+func testDatabase(t *testing.T) *Database {
+	connURI := os.Getenv("TEST_DATABASE_CONN_URI")
+	if connURI == "" {
+		t.Skip("TEST_DATABASE_CONN_URI is empty")
+	}
+
+	db, err := NewDatabase(connURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.updates.RemoveAll(nil)
+	db.meta.RemoveId("scheduler")
+	return db
+}
+
+func TestClaimUpdateRetry(t *testing.T) {
+	db := testDatabase(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.ClaimUpdate(ctx, 1, "a", time.Minute); err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	// As when the first claim reached the database but timed out
+	if err := db.ClaimUpdate(ctx, 1, "a", time.Minute); err != nil {
+		t.Error("Expected the owner to claim again, got", err)
+	}
+	if err := db.ClaimUpdate(ctx, 1, "b", time.Minute); err != ErrDuplicate {
+		t.Error("Expected ErrDuplicate for another owner, got", err)
+	}
+
+	if err := db.FinishUpdate(ctx, 1); err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if err := db.ClaimUpdate(ctx, 1, "a", time.Minute); err != ErrDuplicate {
+		t.Error("Expected ErrDuplicate once finished, got", err)
+	}
+}
+
+func TestClaimScheduler(t *testing.T) {
+	db := testDatabase(t)
+	defer db.Close()
+	ctx := context.Background()
+
+	if err := db.ClaimScheduler(ctx, "a", time.Minute); err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if err := db.ClaimScheduler(ctx, "a", time.Minute); err != nil {
+		t.Error("Expected the owner to renew its claim, got", err)
+	}
+	if err := db.ClaimScheduler(ctx, "b", time.Minute); err != ErrDuplicate {
+		t.Error("Expected ErrDuplicate for another owner, got", err)
+	}
+}
@@ -0,0 +1,156 @@
+package monebot
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Limits of dice notation, so that rolls stay small
+const (
+	maxDiceCount = 100
+	maxDiceSides = 1000
+	maxDiceTerms = 10
+)
+
+// maxShownFaces is how many dice of a term the breakdown shows one by one,
+// more are only summed, keeping it far below telegram's message length limit
+const maxShownFaces = 20
+
+func init() {
+	RegisterPlaceholder("roll", renderRoll)
+}
+
+// Dice is a sum of dice and constants, as in 2d6+3
+type Dice []DiceTerm
+
+// DiceTerm is Count dice of Sides sides, or a constant if Sides is zero,
+// added or subtracted according to Sign
+type DiceTerm struct {
+	Sign  int
+	Count int
+	Sides int
+}
+
+// DiceRoll is the result of rolling Dice
+type DiceRoll struct {
+	Total int
+
+	// Breakdown shows every die, e.g. "2d6 (3, 5) + 3", or only the sum of
+	// terms with more than maxShownFaces dice, e.g. "100d6 (= 352)"
+	Breakdown string
+}
+
+// ParseDice parses dice notation: terms like 2d6, d20 or 3 joined by + or -
+func ParseDice(s string) (Dice, error) {
+	s = strings.ToLower(strings.Replace(s, " ", "", -1))
+	if s == "" {
+		return nil, fmt.Errorf("no dice")
+	}
+
+	var dice Dice
+	sign := 1
+	for s != "" {
+		end := strings.IndexAny(s, "+-")
+		if end == -1 {
+			end = len(s)
+		}
+		term, err := parseDiceTerm(s[:end])
+		if err != nil {
+			return nil, err
+		}
+		term.Sign = sign
+		dice = append(dice, term)
+
+		if len(dice) > maxDiceTerms {
+			return nil, fmt.Errorf("more than %d terms", maxDiceTerms)
+		}
+		if end == len(s) {
+			break
+		}
+
+		sign = 1
+		if s[end] == '-' {
+			sign = -1
+		}
+		s = s[end+1:]
+		if s == "" {
+			return nil, fmt.Errorf("missing term at the end")
+		}
+	}
+	return dice, nil
+}
+
+func parseDiceTerm(s string) (DiceTerm, error) {
+	d := strings.IndexByte(s, 'd')
+	if d == -1 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxDiceCount*maxDiceSides {
+			return DiceTerm{}, fmt.Errorf("invalid term '%s'", s)
+		}
+		return DiceTerm{Count: n}, nil
+	}
+
+	count := 1
+	if d > 0 {
+		var err error
+		if count, err = strconv.Atoi(s[:d]); err != nil || count < 1 || count > maxDiceCount {
+			return DiceTerm{}, fmt.Errorf("invalid number of dice in '%s', up to %d", s, maxDiceCount)
+		}
+	}
+	sides, err := strconv.Atoi(s[d+1:])
+	if err != nil || sides < 2 || sides > maxDiceSides {
+		return DiceTerm{}, fmt.Errorf("invalid sides in '%s', 2 to %d", s, maxDiceSides)
+	}
+	return DiceTerm{Count: count, Sides: sides}, nil
+}
+
+// Roll rolls the dice
+func (dice Dice) Roll(r *rand.Rand) DiceRoll {
+	var roll DiceRoll
+	for i, term := range dice {
+		switch {
+		case i > 0 && term.Sign < 0:
+			roll.Breakdown += " - "
+		case i > 0:
+			roll.Breakdown += " + "
+		case term.Sign < 0:
+			roll.Breakdown += "-"
+		}
+
+		if term.Sides == 0 {
+			roll.Total += term.Sign * term.Count
+			roll.Breakdown += strconv.Itoa(term.Count)
+			continue
+		}
+
+		var faces []string
+		sum := 0
+		for j := 0; j < term.Count; j++ {
+			face := 1 + r.Intn(term.Sides)
+			sum += face
+			if term.Count <= maxShownFaces {
+				faces = append(faces, strconv.Itoa(face))
+			}
+		}
+		roll.Total += term.Sign * sum
+
+		if faces == nil {
+			roll.Breakdown += fmt.Sprintf("%dd%d (= %d)", term.Count, term.Sides, sum)
+		} else {
+			roll.Breakdown += fmt.Sprintf("%dd%d (%s)", term.Count, term.Sides, strings.Join(faces, ", "))
+		}
+	}
+	return roll
+}
+
+// renderRoll answers {roll:2d6+3} with the total of a roll
+func renderRoll(ctx context.Context, r *Renderer, arg string) (string, error) {
+	dice, err := ParseDice(arg)
+	if err != nil {
+		return "", fmt.Errorf("%s in {roll:%s}", err, arg)
+	}
+	return strconv.Itoa(dice.Roll(r.Rand).Total), nil
+}
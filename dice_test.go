@@ -0,0 +1,58 @@
+package monebot
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	dice, err := ParseDice("2d6 + 3 - d4")
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+
+	want := Dice{{1, 2, 6}, {1, 3, 0}, {-1, 1, 4}}
+	if len(dice) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, dice)
+	}
+	for i := range want {
+		if dice[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], dice[i])
+		}
+	}
+
+	for _, s := range []string{"", "d", "2d", "0d6", "1d1", "1d6+", "101d6", "1d1001", "x", "1d6*2",
+		"1+1+1+1+1+1+1+1+1+1+1"} {
+		if _, err := ParseDice(s); err == nil {
+			t.Errorf("Expected error parsing '%s'", s)
+		}
+	}
+}
+
+func TestRollDice(t *testing.T) {
+	dice, _ := ParseDice("3d6-2")
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		roll := dice.Roll(r)
+		if roll.Total < 1 || roll.Total > 16 {
+			t.Fatal("Expected 1 to 16, got", roll.Total)
+		}
+	}
+
+	dice, _ = ParseDice("5")
+	if roll := dice.Roll(r); roll.Total != 5 || roll.Breakdown != "5" {
+		t.Errorf("Expected 5, got %d from %s", roll.Total, roll.Breakdown)
+	}
+}
+
+func TestRollBreakdownLength(t *testing.T) {
+	dice, _ := ParseDice(strings.TrimSuffix(strings.Repeat("100d1000+", maxDiceTerms), "+"))
+	roll := dice.Roll(rand.New(rand.NewSource(1)))
+	if len(roll.Breakdown) > 1000 {
+		t.Errorf("Expected a short breakdown, got %d characters", len(roll.Breakdown))
+	}
+	if !strings.HasPrefix(roll.Breakdown, "100d1000 (= ") {
+		t.Error("Expected only the sum of 100 dice, got", roll.Breakdown)
+	}
+}
@@ -0,0 +1,327 @@
+// Package expr evaluates small expressions for answer templates, such as
+// round($1 / $2, 2) or $1 > 10 ? "lots" : "few". Expressions have no side
+// effects and no loops, and their evaluation is limited in steps and in the
+// length of strings, so that no expression can hang or exhaust the bot.
+//
+// Values are numbers, strings and booleans. Strings are converted to numbers
+// by arithmetic, and anything is converted to a string by +
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Limits of an evaluation, unless set in Env
+const (
+	DefaultMaxSteps     = 10000
+	DefaultMaxStringLen = 4096
+	MaxSourceLen        = 1024
+)
+
+var (
+	ErrSteps     = errors.New("expression took too many steps")
+	ErrStringLen = errors.New("expression made a string too long")
+)
+
+// Env holds the variables of an evaluation and its limits
+type Env struct {
+	// Vars are referenced by name, e.g. $1
+	Vars map[string]interface{}
+
+	// Rand is used by rand(), which fails if it is nil
+	Rand *rand.Rand
+
+	MaxSteps     int
+	MaxStringLen int
+}
+
+// Eval evaluates the expression in the environment
+func Eval(src string, env Env) (interface{}, error) {
+	if len(src) > MaxSourceLen {
+		return nil, fmt.Errorf("expression longer than %d", MaxSourceLen)
+	}
+
+	n, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.MaxSteps == 0 {
+		env.MaxSteps = DefaultMaxSteps
+	}
+	if env.MaxStringLen == 0 {
+		env.MaxStringLen = DefaultMaxStringLen
+	}
+	return n.eval(&evaluator{env: env})
+}
+
+// Format returns the value as text, numbers without trailing zeros
+func Format(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+type evaluator struct {
+	env   Env
+	steps int
+}
+
+// step counts n steps of work, failing past the limit
+func (e *evaluator) step(n int) error {
+	e.steps += n
+	if e.steps > e.env.MaxSteps {
+		return ErrSteps
+	}
+	return nil
+}
+
+// str checks the length of a string made by the expression
+func (e *evaluator) str(s string) (interface{}, error) {
+	if len(s) > e.env.MaxStringLen {
+		return nil, ErrStringLen
+	}
+	return s, e.step(len(s) / 64)
+}
+
+// toNumber converts numbers and numeric strings to float64
+func toNumber(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not a number", v)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// truthy is false for false, zero and the empty string
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+type node interface {
+	eval(e *evaluator) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n literalNode) eval(e *evaluator) (interface{}, error) {
+	return n.value, e.step(1)
+}
+
+type varNode struct {
+	name string
+}
+
+func (n varNode) eval(e *evaluator) (interface{}, error) {
+	v, ok := e.env.Vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable '%s'", n.name)
+	}
+	return v, e.step(1)
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n unaryNode) eval(e *evaluator) (interface{}, error) {
+	v, err := n.operand.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		return !truthy(v), nil
+	}
+	f, err := toNumber(v)
+	return -f, err
+}
+
+type condNode struct {
+	cond, then, otherwise node
+}
+
+func (n condNode) eval(e *evaluator) (interface{}, error) {
+	c, err := n.cond.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(c) {
+		return n.then.eval(e)
+	}
+	return n.otherwise.eval(e)
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n binaryNode) eval(e *evaluator) (interface{}, error) {
+	l, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit, returning booleans
+	switch n.op {
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+		r, err := n.right.eval(e)
+		return truthy(r), err
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+		r, err := n.right.eval(e)
+		return truthy(r), err
+	}
+
+	r, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compare(n.op, l, r), nil
+	}
+
+	_, lString := l.(string)
+	_, rString := r.(string)
+	if n.op == "+" && (lString || rString) {
+		// Numeric strings are still added as numbers
+		lf, lErr := toNumber(l)
+		rf, rErr := toNumber(r)
+		if lErr != nil || rErr != nil {
+			return e.str(Format(l) + Format(r))
+		}
+		return lf + rf, nil
+	}
+
+	lf, err := toNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := toNumber(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+// compare compares numerically if both values are numbers, and as text
+// otherwise
+func compare(op string, l, r interface{}) bool {
+	var c int
+	lf, lErr := toNumber(l)
+	rf, rErr := toNumber(r)
+	switch {
+	case lErr == nil && rErr == nil:
+		if lf < rf {
+			c = -1
+		} else if lf > rf {
+			c = 1
+		}
+	default:
+		c = strings.Compare(Format(l), Format(r))
+	}
+
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+type callNode struct {
+	name string
+	f    function
+	args []node
+}
+
+func (n callNode) eval(e *evaluator) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if err := e.step(1); err != nil {
+		return nil, err
+	}
+
+	v, err := n.f.call(e, args)
+	if err != nil && err != ErrSteps && err != ErrStringLen {
+		return nil, fmt.Errorf("%s: %s", n.name, err)
+	}
+	return v, err
+}
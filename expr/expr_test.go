@@ -0,0 +1,82 @@
+package expr
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{Vars: map[string]interface{}{"$1": "120", "$2": "4", "$3": "Ana"}}
+	cases := map[string]string{
+		`1 + 2 * 3`:                          "7",
+		`(1 + 2) * 3`:                        "9",
+		`-2 - -3`:                            "1",
+		`7 % 4`:                              "3",
+		`$1 / $2`:                            "30",
+		`round(10 / 3, 2)`:                   "3.33",
+		`"Hi, " + $3 + "!"`:                  "Hi, Ana!",
+		`upper($3) + len($3)`:                "ANA3",
+		`$1 > 100 ? "lots" : "few"`:          "lots",
+		`$3 == "Ana" && !($2 < 2)`:           "true",
+		`false || 0`:                         "false",
+		`substr("monebot", 4)`:               "bot",
+		`replace("a-b-c", "-", "+")`:         "a+b+c",
+		`max(1, 5, 3) + min(2, floor(-1.5))`: "3",
+		`contains('it\'s', "'")`:             "true",
+	}
+	for src, want := range cases {
+		v, err := Eval(src, env)
+		if err != nil {
+			t.Errorf("Eval(%s): unexpected error %s", src, err)
+			continue
+		}
+		if got := Format(v); got != want {
+			t.Errorf("Eval(%s) = %s, expected %s", src, got, want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for _, src := range []string{
+		``, `1 +`, `(1`, `1 / 0`, `"a" * 2`, `nope(1)`, `upper()`, `$9`,
+		`"unterminated`, `1 # 2`, `rand(1, 6)`, `repeat("a", -1)`,
+	} {
+		if _, err := Eval(src, Env{}); err == nil {
+			t.Errorf("Eval(%s): expected error", src)
+		}
+	}
+}
+
+func TestEvalLimits(t *testing.T) {
+	if _, err := Eval(`repeat("abc", 100000)`, Env{}); err != ErrStringLen {
+		t.Error("Expected string length error, got", err)
+	}
+
+	long := strings.Repeat("1+", 200) + "1"
+	if _, err := Eval(long, Env{MaxSteps: 100}); err != ErrSteps {
+		t.Error("Expected steps error, got", err)
+	}
+
+	deep := strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)
+	if _, err := Eval(deep, Env{}); err == nil {
+		t.Error("Expected nesting error")
+	}
+
+	if _, err := Eval(strings.Repeat(" ", MaxSourceLen+1), Env{}); err == nil {
+		t.Error("Expected source length error")
+	}
+}
+
+func TestEvalRand(t *testing.T) {
+	env := Env{Rand: rand.New(rand.NewSource(1))}
+	for i := 0; i < 100; i++ {
+		v, err := Eval(`rand(1, 6)`, env)
+		if err != nil {
+			t.Fatal("Expected no error, got", err)
+		}
+		if n := v.(float64); n < 1 || n > 6 {
+			t.Fatal("Expected 1 to 6, got", n)
+		}
+	}
+}
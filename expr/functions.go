@@ -0,0 +1,177 @@
+package expr
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRandRange is the widest range rand() picks from
+const maxRandRange = 1 << 31
+
+type function struct {
+	minArgs, maxArgs int
+	call             func(e *evaluator, args []interface{}) (interface{}, error)
+}
+
+// functions are keyed by the names used to call them
+var functions map[string]function
+
+func init() {
+	functions = map[string]function{
+		"len": {1, 1, func(e *evaluator, args []interface{}) (interface{}, error) {
+			return float64(utf8.RuneCountInString(Format(args[0]))), nil
+		}},
+		"upper": {1, 1, stringFunc(strings.ToUpper)},
+		"lower": {1, 1, stringFunc(strings.ToLower)},
+		"trim":  {1, 1, stringFunc(strings.TrimSpace)},
+		"str": {1, 1, func(e *evaluator, args []interface{}) (interface{}, error) {
+			return Format(args[0]), nil
+		}},
+		"num": {1, 1, func(e *evaluator, args []interface{}) (interface{}, error) {
+			return toNumber(args[0])
+		}},
+		"contains": {2, 2, func(e *evaluator, args []interface{}) (interface{}, error) {
+			return strings.Contains(Format(args[0]), Format(args[1])), e.step(len(Format(args[0])) / 64)
+		}},
+		"replace": {3, 3, func(e *evaluator, args []interface{}) (interface{}, error) {
+			s, old := Format(args[0]), Format(args[1])
+			if old == "" {
+				return s, nil
+			}
+
+			// Check the length before building the string
+			n := strings.Count(s, old)
+			if len(s)+n*(len(Format(args[2]))-len(old)) > e.env.MaxStringLen {
+				return nil, ErrStringLen
+			}
+			return e.str(strings.Replace(s, old, Format(args[2]), -1))
+		}},
+		"repeat": {2, 2, func(e *evaluator, args []interface{}) (interface{}, error) {
+			s := Format(args[0])
+			n, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 || len(s) > 0 && n > e.env.MaxStringLen/len(s) {
+				return nil, ErrStringLen
+			}
+			return e.str(strings.Repeat(s, n))
+		}},
+		"substr": {2, 3, func(e *evaluator, args []interface{}) (interface{}, error) {
+			runes := []rune(Format(args[0]))
+			start, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			end := len(runes)
+			if len(args) == 3 {
+				if end, err = toInt(args[2]); err != nil {
+					return nil, err
+				}
+			}
+
+			start, end = clamp(start, 0, len(runes)), clamp(end, 0, len(runes))
+			if start > end {
+				return "", nil
+			}
+			return string(runes[start:end]), nil
+		}},
+		"round": {1, 2, func(e *evaluator, args []interface{}) (interface{}, error) {
+			f, err := toNumber(args[0])
+			if err != nil {
+				return nil, err
+			}
+			digits := 0
+			if len(args) == 2 {
+				if digits, err = toInt(args[1]); err != nil {
+					return nil, err
+				}
+				digits = clamp(digits, 0, 15)
+			}
+
+			pow := math.Pow(10, float64(digits))
+			return math.Floor(f*pow+0.5) / pow, nil
+		}},
+		"floor": {1, 1, numberFunc(math.Floor)},
+		"ceil":  {1, 1, numberFunc(math.Ceil)},
+		"abs":   {1, 1, numberFunc(math.Abs)},
+		"sqrt":  {1, 1, numberFunc(math.Sqrt)},
+		"min":   {1, 16, fold(math.Min)},
+		"max":   {1, 16, fold(math.Max)},
+		"rand": {2, 2, func(e *evaluator, args []interface{}) (interface{}, error) {
+			if e.env.Rand == nil {
+				return nil, errors.New("no random numbers here")
+			}
+			min, err := toInt(args[0])
+			if err != nil {
+				return nil, err
+			}
+			max, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if max < min || max-min >= maxRandRange {
+				return nil, errors.New("invalid range")
+			}
+			return float64(min + e.env.Rand.Intn(max-min+1)), nil
+		}},
+	}
+}
+
+func stringFunc(f func(string) string) func(*evaluator, []interface{}) (interface{}, error) {
+	return func(e *evaluator, args []interface{}) (interface{}, error) {
+		return e.str(f(Format(args[0])))
+	}
+}
+
+func numberFunc(f func(float64) float64) func(*evaluator, []interface{}) (interface{}, error) {
+	return func(e *evaluator, args []interface{}) (interface{}, error) {
+		x, err := toNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(x), nil
+	}
+}
+
+func fold(f func(float64, float64) float64) func(*evaluator, []interface{}) (interface{}, error) {
+	return func(e *evaluator, args []interface{}) (interface{}, error) {
+		result, err := toNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args[1:] {
+			x, err := toNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			result = f(result, x)
+		}
+		return result, nil
+	}
+}
+
+// toInt converts the value to an integer, failing if it has decimals or is
+// too large
+func toInt(v interface{}) (int, error) {
+	f, err := toNumber(v)
+	if err != nil {
+		return 0, err
+	}
+	if f != math.Trunc(f) || math.Abs(f) > 1<<31 {
+		return 0, errors.New(Format(v) + " is not a valid integer")
+	}
+	return int(f), nil
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
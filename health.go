@@ -0,0 +1,104 @@
+package monebot
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// healthCheckInterval is how often the database connection is checked
+const healthCheckInterval = 10 * time.Second
+
+// Health is the result of a database health check
+type Health struct {
+	Healthy   bool
+	Checked   time.Time
+	Err       error
+	Since     time.Time // When Healthy last changed
+	Reconnect int       // Reconnection attempts since last healthy
+}
+
+// healthMonitor periodically pings the database, refreshing the session
+// (which drops its sockets and reconnects) whenever the ping fails
+type healthMonitor struct {
+	session *mgo.Session
+
+	mu     sync.RWMutex
+	health Health
+
+	stop chan struct{}
+}
+
+func newHealthMonitor(session *mgo.Session) *healthMonitor {
+	now := time.Now()
+	m := &healthMonitor{
+		session: session,
+		health:  Health{Healthy: true, Checked: now, Since: now},
+		stop:    make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+func (m *healthMonitor) run() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *healthMonitor) check() {
+	s := m.session.Copy()
+	s.SetSocketTimeout(healthCheckInterval / 2)
+	err := s.Ping()
+	s.Close()
+
+	if err != nil {
+		// Drop the broken sockets so the next operations reconnect
+		m.session.Refresh()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	healthy := err == nil
+	if healthy != m.health.Healthy {
+		m.health.Since = now
+		if healthy {
+			log.Println("Database is healthy again")
+		} else {
+			log.Println("Database is unhealthy:", err)
+		}
+	}
+
+	if healthy {
+		m.health.Reconnect = 0
+	} else {
+		m.health.Reconnect++
+	}
+	m.health.Healthy = healthy
+	m.health.Checked = now
+	m.health.Err = err
+}
+
+// Health returns the result of the last check
+func (m *healthMonitor) Health() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health
+}
+
+// Stop checking the database
+func (m *healthMonitor) Stop() {
+	close(m.stop)
+}
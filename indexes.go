@@ -0,0 +1,237 @@
+package monebot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// indexTimeout is how long ensuring indexes may take
+const indexTimeout = time.Minute
+
+// updatesTTL is how long processed updates are remembered for deduplication,
+// telegram itself keeps undelivered updates for 24 hours
+const updatesTTL = 48 * time.Hour
+
+// rerollsTTL is how long the messages of random answers can be rerolled
+const rerollsTTL = 48 * time.Hour
+
+// EnsureIndexes creates every index the queries rely on. It fails if
+// duplicates violate a unique index, which Repair removes
+func (db *Database) EnsureIndexes(ctx context.Context) error {
+	return db.with(ctx, func(s *mgo.Session) error {
+		indexes := []struct {
+			c     *mgo.Collection
+			index mgo.Index
+		}{
+			// FindCommand and UpsertCommand
+			{db.commands, mgo.Index{Key: []string{"name", "answer.numParams", "pack"}, Unique: true}},
+			// A pack name is unique and a chat uses only one pack
+			{db.packs, mgo.Index{Key: []string{"name"}, Unique: true}},
+			{db.packs, mgo.Index{Key: []string{"chats"}, Unique: true, Sparse: true}},
+			// One conversation per user in each chat
+			{db.states, mgo.Index{Key: []string{"chat", "user"}, Unique: true}},
+			// One settings document per chat
+			{db.settings, mgo.Index{Key: []string{"chat"}, Unique: true}},
+			// Forget processed updates eventually
+			{db.updates, mgo.Index{Key: []string{"time"}, ExpireAfter: updatesTTL}},
+			// One reroll per message, forgotten eventually
+			{db.rerolls, mgo.Index{Key: []string{"chat", "message"}, Unique: true}},
+			{db.rerolls, mgo.Index{Key: []string{"time"}, ExpireAfter: rerollsTTL}},
+			// One variable per name in each scope
+			{db.vars, mgo.Index{Key: []string{"scope", "name"}, Unique: true}},
+			// DueReminders and ListReminders
+			{db.reminders, mgo.Index{Key: []string{"due"}}},
+			{db.reminders, mgo.Index{Key: []string{"chat", "due"}}},
+			// DueSchedules and ListSchedules
+			{db.schedules, mgo.Index{Key: []string{"next"}}},
+			{db.schedules, mgo.Index{Key: []string{"chat", "next"}}},
+		}
+
+		for _, i := range indexes {
+			err := i.c.With(s).EnsureIndex(i.index)
+			if mgo.IsDup(err) {
+				return fmt.Errorf("duplicates in %s block the unique index %v, run 'monebotctl repair': %s",
+					i.c.Name, i.index.Key, err)
+			}
+			if err != nil {
+				log.Printf("Error ensuring index %v on %s: %s\n", i.index.Key, i.c.Name, err)
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Repair is a change to documents that violate a unique index
+type Repair struct {
+	Collection string      `json:"collection"`
+	Key        interface{} `json:"key"`
+	Action     string      `json:"action"`
+	Documents  int         `json:"documents"`
+}
+
+// Repair finds the duplicates that would violate the unique indexes,
+// removing or merging them unless dryRun, and returns what it found
+func (db *Database) Repair(ctx context.Context, dryRun bool) ([]Repair, error) {
+	var repairs []Repair
+	err := db.with(ctx, func(s *mgo.Session) error {
+		found, err := repairDuplicates(db.commands.With(s), dryRun, "time",
+			"name", "answer.numParams", "pack")
+		repairs = append(repairs, found...)
+		if err != nil {
+			return err
+		}
+		found, err = repairDuplicates(db.states.With(s), dryRun, "lastUpdate",
+			"chat", "user")
+		repairs = append(repairs, found...)
+		if err != nil {
+			return err
+		}
+		found, err = repairDuplicates(db.settings.With(s), dryRun, "_id", "chat")
+		repairs = append(repairs, found...)
+		if err != nil {
+			return err
+		}
+		found, err = repairPacks(db.packs.With(s), dryRun)
+		repairs = append(repairs, found...)
+		return err
+	})
+	return repairs, err
+}
+
+// repairDuplicates finds documents with the same values for keys, removing
+// all but the one with the latest value in the time field unless dryRun
+func repairDuplicates(c *mgo.Collection, dryRun bool, timeField string, keys ...string) ([]Repair, error) {
+	// Group field names can't contain dots
+	group := bson.M{}
+	for _, key := range keys {
+		group[strings.Replace(key, ".", "_", -1)] = "$" + key
+	}
+
+	iter := c.Pipe([]bson.M{
+		{"$sort": bson.M{timeField: -1}},
+		{"$group": bson.M{
+			"_id":   group,
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}).AllowDiskUse().Iter()
+
+	var repairs []Repair
+	var dup struct {
+		ID  bson.M        `bson:"_id"`
+		IDs []interface{} `bson:"ids"`
+	}
+	for iter.Next(&dup) {
+		log.Printf("Found %d duplicates in %s for %v, keeping the latest\n",
+			len(dup.IDs)-1, c.Name, dup.ID)
+		repairs = append(repairs, Repair{c.Name, dup.ID, "remove all but the latest", len(dup.IDs) - 1})
+		if dryRun {
+			continue
+		}
+
+		_, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": dup.IDs[1:]}})
+		if err != nil {
+			iter.Close()
+			return repairs, err
+		}
+	}
+
+	return repairs, iter.Close()
+}
+
+// repairPacks merges packs with the same name, removes chats from all but
+// one of the packs using them and unsets the chats left empty, only finding
+// them if dryRun
+func repairPacks(c *mgo.Collection, dryRun bool) ([]Repair, error) {
+	var repairs []Repair
+	var dup struct {
+		ID    string          `bson:"_id"`
+		IDs   []bson.ObjectId `bson:"ids"`
+		Chats [][]int64       `bson:"chats"`
+	}
+
+	iter := c.Pipe([]bson.M{
+		{"$group": bson.M{
+			"_id":   "$name",
+			"ids":   bson.M{"$push": "$_id"},
+			"chats": bson.M{"$push": "$chats"},
+			"count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}).Iter()
+	for iter.Next(&dup) {
+		log.Printf("Found %d duplicates of pack '%s', merging them\n", len(dup.IDs)-1, dup.ID)
+		repairs = append(repairs, Repair{c.Name, dup.ID, "merge into one pack", len(dup.IDs) - 1})
+		if dryRun {
+			continue
+		}
+
+		var chats []int64
+		for _, c := range dup.Chats {
+			chats = append(chats, c...)
+		}
+
+		err := c.UpdateId(dup.IDs[0], bson.M{"$addToSet": bson.M{"chats": bson.M{"$each": chats}}})
+		if err == nil {
+			_, err = c.RemoveAll(bson.M{"_id": bson.M{"$in": dup.IDs[1:]}})
+		}
+		if err != nil {
+			iter.Close()
+			return repairs, err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return repairs, err
+	}
+
+	var shared struct {
+		Chat  int64    `bson:"_id"`
+		Packs []string `bson:"packs"`
+	}
+
+	iter = c.Pipe([]bson.M{
+		{"$unwind": "$chats"},
+		{"$sort": bson.M{"name": 1}},
+		{"$group": bson.M{
+			"_id":   "$chats",
+			"packs": bson.M{"$push": "$name"},
+			"count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}).Iter()
+	for iter.Next(&shared) {
+		log.Printf("Chat %d is in packs %v, keeping it in '%s'\n",
+			shared.Chat, shared.Packs, shared.Packs[0])
+		repairs = append(repairs, Repair{c.Name, shared.Chat, "keep the chat only in " + shared.Packs[0], len(shared.Packs) - 1})
+		if dryRun {
+			continue
+		}
+
+		_, err := c.UpdateAll(bson.M{"name": bson.M{"$in": shared.Packs[1:]}},
+			bson.M{"$pull": bson.M{"chats": shared.Chat}})
+		if err != nil {
+			iter.Close()
+			return repairs, err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return repairs, err
+	}
+
+	empty, err := c.Find(bson.M{"chats": bson.M{"$size": 0}}).Count()
+	if err != nil || empty == 0 {
+		return repairs, err
+	}
+	log.Printf("Found %d packs with an empty list of chats, unsetting it\n", empty)
+	repairs = append(repairs, Repair{c.Name, "chats", "unset the empty list", empty})
+	if dryRun {
+		return repairs, nil
+	}
+	return repairs, unsetEmptyChats(c)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/victormoneratto/monebot/util"
 	"strings"
+	"time"
 )
 
 func MessageSavedCommand(c Command) (Text, Parse string) {
@@ -30,6 +31,18 @@ func MessageCommandInfo(c Command) (Text, Parse string){
 		util.EscapeMarkdown(c.Answer.Text),
 		creator, year, month, day)
 
+	if n := len(c.Answer.Alternatives); n > 0 {
+		Text += fmt.Sprintf("\n*Picks one of* `%d` *answers*", n+1)
+	}
+	for _, r := range c.Rules {
+		Text += fmt.Sprintf("\n*When* `%s`: _%s_", r, util.EscapeMarkdown(r.Answer.Text))
+	}
+
+	for i := len(c.Via) - 1; i >= 0; i-- {
+		Text = fmt.Sprintf("*%s* is an alias of *%s*\n", util.EscapeMarkdown(c.Via[i].FullName()),
+			util.EscapeMarkdown(c.Via[i].Alias.FullName())) + Text
+	}
+
 	Parse = ParseMarkdown
 
 	return
@@ -47,4 +60,462 @@ func MessageMissingContent() (Text, Parse string) {
 	Parse = ""
 
 	return
-}
\ No newline at end of file
+}
+func MessageReadOnly() (Text, Parse string) {
+	Text = "Sorry, I can't save anything right now, my memory is having trouble. Try again in a few minutes"
+	Parse = ""
+
+	return
+}
+
+func MessagePackUsage() (Text, Parse string) {
+	Text = "*/pack export* `[name] [history] [json|yaml]`\n" +
+		"_Sends the pack's commands as a file_\n\n" +
+		"*/pack import* `[skip|overwrite|rename]`\n" +
+		"_In reply to an exported file, saves its commands into this chat's own pack (admins only in groups)_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageMissingBundle() (Text, Parse string) {
+	Text = "Please, reply to an exported pack file"
+	Parse = ""
+
+	return
+}
+
+func MessageInvalidBundle(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I couldn't import this file: %s", err)
+	Parse = ""
+
+	return
+}
+
+func MessageImportedBundle(r ImportResult) (Text, Parse string) {
+	pack := r.Pack
+	if pack == "" {
+		pack = "default"
+	}
+
+	Text = fmt.Sprintf("Imported *%d* commands into *%s*", len(r.Imported), util.EscapeMarkdown(pack))
+	if len(r.Skipped) > 0 {
+		Text += fmt.Sprintf("\n*Skipped* existing %s",
+			util.EscapeMarkdown(strings.Join(r.Skipped, ", ")))
+	}
+	for from, to := range r.Renamed {
+		Text += fmt.Sprintf("\n*Renamed* %s *to* %s", util.EscapeMarkdown(from), util.EscapeMarkdown(to))
+	}
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageStateExpired(user int, ttl time.Duration, command string) (Text, Parse string) {
+	Text = fmt.Sprintf("[Hey](tg://user?id=%d), I gave up waiting for your answer after %s. "+
+		"Send /%s to start again", user, ttl, util.EscapeMarkdown(command))
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageCancelled() (Text, Parse string) {
+	Text = "Ok, forget it"
+	Parse = ""
+
+	return
+}
+
+func MessageNothingToCancel() (Text, Parse string) {
+	Text = "There's nothing to cancel"
+	Parse = ""
+
+	return
+}
+
+func MessageNoConversation() (Text, Parse string) {
+	Text = "We're not in the middle of anything"
+	Parse = ""
+
+	return
+}
+
+func MessageUnsupportedInput() (Text, Parse string) {
+	Text = "Sorry, I can't understand this kind of message"
+	Parse = ""
+
+	return
+}
+
+func MessageExpectedText() string {
+	return "Please, send me some text"
+}
+
+func MessageExpectedMedia() string {
+	return "Please, send me a sticker, photo or file"
+}
+
+func MessageExpectedChoice() string {
+	return "Please, pick one of the options"
+}
+
+func MessageExpectedTextOrSticker() string {
+	return "Please, send me some text or a sticker"
+}
+
+func MessageSettings() (Text, Parse string) {
+	Text = "*Settings* for this chat\n_Tap to change_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageAdminsOnly() (Text, Parse string) {
+	Text = "Only admins can change my settings here"
+	Parse = ""
+
+	return
+}
+
+func MessageUnknownCommand(name string) (Text, Parse string) {
+	Text = fmt.Sprintf("I don't know /%s yet, teach me with /neverforget", name)
+	Parse = ""
+
+	return
+}
+
+func MessageAliasUsage() (Text, Parse string) {
+	Text = "*/alias* `[--force] new existing`\n" +
+		"_Makes /new answer the same as /existing, replacing the command /new with_ `--force` _(admins only in groups)_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageAliasCycle() (Text, Parse string) {
+	Text = "That alias would end up pointing to itself"
+	Parse = ""
+
+	return
+}
+
+func MessageAliasShadowed(alias CommandRef) (Text, Parse string) {
+	Text = fmt.Sprintf("There's already a command /%s, send /alias --force to replace it", alias.Name)
+	Parse = ""
+
+	return
+}
+
+func MessageSavedAlias(alias, target Command) (Text, Parse string) {
+	Text = fmt.Sprintf("Saved *%s* as an alias of *%s*",
+		util.EscapeMarkdown(alias.FullName()), util.EscapeMarkdown(target.FullName()))
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageNeverForgetUsage() (Text, Parse string) {
+	Text = "*/neverforget* `[--add] [--weight=N] name content`\n" +
+		"_Saves the content as /name. With --add, it becomes one more answer picked at random, " +
+		"N times as likely as the others_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageAddedAlternative(c Command) (Text, Parse string) {
+	Text = fmt.Sprintf("Added answer to *%s*, now picking one of `%d`",
+		util.EscapeMarkdown(c.FullName()), len(c.Answer.Alternatives)+1)
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageAnotherOne() (Text, Parse string) {
+	Text = "🎲 Another one"
+	Parse = ""
+
+	return
+}
+
+func MessageNoMoreRerolls() (Text, Parse string) {
+	Text = "That's all for this one"
+	Parse = ""
+
+	return
+}
+
+func MessageRenderFailed(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I couldn't put this answer together: %s", err)
+	Parse = ""
+
+	return
+}
+
+func MessageVarUsage() (Text, Parse string) {
+	Text = "*/var set* `name value`\n" +
+		"*/var get* `name`\n" +
+		"*/var reset* `name`\n" +
+		"_Variables are used in answers as {var:name} or {counter:name++}. " +
+		"Names like pack.name are shared by every chat using the pack, and only changed there (admins only in groups)_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageVariable(name string, v Variable) (Text, Parse string) {
+	Text = fmt.Sprintf("%s = %s", name, v)
+	Parse = ""
+
+	return
+}
+
+func MessageForeignPack() (Text, Parse string) {
+	Text = "Only chats using the pack can change its variables"
+	Parse = ""
+
+	return
+}
+
+func MessageVariableUnset(name string) (Text, Parse string) {
+	Text = fmt.Sprintf("%s is not set", name)
+	Parse = ""
+
+	return
+}
+
+func MessageWhenUsage() (Text, Parse string) {
+	Text = "*/when* `name conditions content`\n" +
+		"_Answers /name with the content instead when all conditions hold, e.g._ " +
+		"`/when lunch fri,11:30-14:00 Pizza day!`\n" +
+		"_Conditions are_ `here`_, a_ `@username`_, weekdays like_ `mon-fri` _or times like_ `22:00-02:00`\n\n" +
+		"*/when* `name clear`\n" +
+		"_Removes the command's conditional answers_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageInvalidRule(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I didn't get the conditions: %s", err)
+	Parse = ""
+
+	return
+}
+
+func MessageSavedRule(c Command, r Rule) (Text, Parse string) {
+	Text = fmt.Sprintf("Saved answer of *%s* when `%s`",
+		util.EscapeMarkdown(c.FullName()), r)
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageClearedRules(name string) (Text, Parse string) {
+	Text = fmt.Sprintf("Removed the conditional answers of *%s*", util.EscapeMarkdown(name))
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageInvalidDice(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I can't roll that: %s. Try something like 2d6+3", err)
+	Parse = ""
+
+	return
+}
+
+func MessageRoll(roll DiceRoll) (Text, Parse string) {
+	Text = fmt.Sprintf("🎲 *%d*\n`%s`", roll.Total, roll.Breakdown)
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageFlip(heads bool) (Text, Parse string) {
+	Text = "🪙 *Tails*"
+	if heads {
+		Text = "🪙 *Heads*"
+	}
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessagePickUsage() (Text, Parse string) {
+	Text = "*/pick* `option, option...`\n" +
+		"_Picks one of the options_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessagePick(option string) (Text, Parse string) {
+	Text = fmt.Sprintf("👉 %s", option)
+	Parse = ""
+
+	return
+}
+
+func MessageRemindUsage() (Text, Parse string) {
+	Text = "*/remindme* `when text`\n" +
+		"*/remind* `@user when text`\n" +
+		"_When is like_ `in 10m`_,_ `2h`_,_ `tomorrow 9:00`_,_ `fri at 6pm` _or_ `2026-12-24 20:00`"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageInvalidWhen(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I didn't get when: %s. Try something like \"in 10m\" or \"tomorrow 9:00\"", err)
+	Parse = ""
+
+	return
+}
+
+func MessageReminderTooFar() (Text, Parse string) {
+	Text = "That's too far away, I can only remind within a year"
+	Parse = ""
+
+	return
+}
+
+func MessageTooManyReminders(max int) (Text, Parse string) {
+	Text = fmt.Sprintf("This chat already has %d reminders, cancel some with /reminders", max)
+	Parse = ""
+
+	return
+}
+
+func MessageReminderSaved(r Reminder, loc *time.Location) (Text, Parse string) {
+	Text = fmt.Sprintf("Ok, I'll remind %s on %s", r.Mention, r.Due.In(loc).Format("Mon Jan 2 15:04 MST"))
+	Parse = ""
+
+	return
+}
+
+func MessageReminders(reminders []Reminder, loc *time.Location) (Text, Parse string) {
+	if len(reminders) == 0 {
+		return "There are no reminders here", ""
+	}
+
+	var lines []string
+	for i, r := range reminders {
+		lines = append(lines, fmt.Sprintf("%d. %s, %s: %s", i+1,
+			r.Due.In(loc).Format("Mon Jan 2 15:04"), r.Mention, r.Text))
+	}
+	Text = strings.Join(lines, "\n") + "\n\nCancel one with /reminders cancel <number>"
+	Parse = ""
+
+	return
+}
+
+func MessageRemindersUsage() (Text, Parse string) {
+	Text = "*/reminders*\n" +
+		"_Lists this chat's reminders_\n\n" +
+		"*/reminders cancel* `number`\n" +
+		"_Cancels a reminder by its number in the list_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageReminderCancelled(r Reminder) (Text, Parse string) {
+	Text = fmt.Sprintf("Cancelled the reminder for %s: %s", r.Mention, r.Text)
+	Parse = ""
+
+	return
+}
+
+func MessageReminder(r Reminder) (Text, Parse string) {
+	mention := util.EscapeMarkdown(r.Mention)
+	if !strings.HasPrefix(r.Mention, "@") {
+		mention = fmt.Sprintf("[Hey](tg://user?id=%s)", r.Mention)
+	}
+
+	Text = fmt.Sprintf("⏰ %s, %s", mention, util.EscapeMarkdown(r.Text))
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageTimezone(loc *time.Location) (Text, Parse string) {
+	Text = fmt.Sprintf("This chat's time zone is %s, it's %s now. "+
+		"Change it with /timezone Area/City, e.g. /timezone America/Sao_Paulo",
+		loc, time.Now().In(loc).Format("15:04"))
+	Parse = ""
+
+	return
+}
+
+func MessageInvalidTimezone(name string) (Text, Parse string) {
+	Text = fmt.Sprintf("I don't know the time zone %s, try one like America/Sao_Paulo", name)
+	Parse = ""
+
+	return
+}
+
+func MessageScheduleUsage() (Text, Parse string) {
+	Text = "*/schedule* `\"cron\" name [once|skip]`\n" +
+		"_Posts /name at the times of the cron expression, in this chat's /timezone, e.g._ " +
+		"`/schedule \"0 9 * * MON\" weekly`\n" +
+		"_Runs missed while I'm down are posted once, or skipped with_ `skip`"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageInvalidCron(err error) (Text, Parse string) {
+	Text = fmt.Sprintf("I can't use that schedule: %s", err)
+	Parse = ""
+
+	return
+}
+
+func MessageTooManySchedules(max int) (Text, Parse string) {
+	Text = fmt.Sprintf("This chat already has %d schedules, cancel some with /schedules", max)
+	Parse = ""
+
+	return
+}
+
+func MessageScheduleSaved(s Schedule, loc *time.Location) (Text, Parse string) {
+	Text = fmt.Sprintf("Ok, I'll post /%s at %s, next on %s", s.Command.Name, s.Cron,
+		s.Next.In(loc).Format("Mon Jan 2 15:04 MST"))
+	Parse = ""
+
+	return
+}
+
+func MessageSchedules(schedules []Schedule, loc *time.Location) (Text, Parse string) {
+	if len(schedules) == 0 {
+		return "There are no schedules here", ""
+	}
+
+	var lines []string
+	for i, s := range schedules {
+		lines = append(lines, fmt.Sprintf("%d. %s at %s, next on %s", i+1,
+			s.Command.FullName(), s.Cron, s.Next.In(loc).Format("Mon Jan 2 15:04")))
+	}
+	Text = strings.Join(lines, "\n") + "\n\nCancel one with /schedules cancel <number>"
+	Parse = ""
+
+	return
+}
+
+func MessageSchedulesUsage() (Text, Parse string) {
+	Text = "*/schedules*\n" +
+		"_Lists this chat's schedules_\n\n" +
+		"*/schedules cancel* `number`\n" +
+		"_Cancels a schedule by its number in the list_"
+	Parse = ParseMarkdown
+
+	return
+}
+
+func MessageScheduleCancelled(s Schedule) (Text, Parse string) {
+	Text = fmt.Sprintf("Cancelled posting %s at %s", s.Command.FullName(), s.Cron)
+	Parse = ""
+
+	return
+}
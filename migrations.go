@@ -0,0 +1,185 @@
+package monebot
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/victormoneratto/monebot/util"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Migration brings the data from the previous schema version to Version.
+// Up must be idempotent, since a migration interrupted midway runs again,
+// and must only log what it would change when dryRun is set
+type Migration struct {
+	Version int                                      `json:"version"`
+	Name    string                                   `json:"name"`
+	Up      func(d *mgo.Database, dryRun bool) error `json:"-"`
+}
+
+// Migrations lists every migration in the order they run, numbered from 1
+var Migrations = []Migration{
+	{1, "Move waiting states to the neverforget flow", migrateWaitingStates},
+	{2, "Normalize command names", migrateCommandNames},
+}
+
+// SchemaVersion returns the version of the last migration applied
+func (db *Database) SchemaVersion(ctx context.Context) (int, error) {
+	var m struct {
+		Version int `bson:"version"`
+	}
+	err := db.with(ctx, func(s *mgo.Session) error {
+		return db.meta.With(s).FindId("schema").One(&m)
+	})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return m.Version, err
+}
+
+// Migrate runs, in order, every migration newer than the schema version,
+// recording the version after each one. It returns the migrations that ran,
+// or that would run on a dry run
+func (db *Database) Migrate(ctx context.Context, dryRun bool) ([]Migration, error) {
+	version, err := db.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		log.Printf("Migrating to version %d: %s\n", m.Version, m.Name)
+		err := db.with(ctx, func(s *mgo.Session) error {
+			if err := m.Up(s.DB(""), dryRun); err != nil {
+				return err
+			}
+			if dryRun {
+				return nil
+			}
+
+			_, err := db.meta.With(s).UpsertId("schema",
+				bson.M{"$set": bson.M{"version": m.Version}})
+			return err
+		})
+		if err != nil {
+			return ran, fmt.Errorf("migration %d failed: %s", m.Version, err)
+		}
+
+		ran = append(ran, m)
+	}
+
+	return ran, nil
+}
+
+func migrateWaitingStates(d *mgo.Database, dryRun bool) error {
+	states := d.C("states")
+	waiting := bson.M{"waiting": bson.M{"$exists": true}}
+	if dryRun {
+		n, err := states.Find(waiting).Count()
+		if err == nil {
+			log.Printf("Would move %d waiting states to flows\n", n)
+		}
+		return err
+	}
+
+	var s struct {
+		ID      bson.ObjectId `bson:"_id"`
+		Waiting struct {
+			ForCommand bool   `bson:"forCommand"`
+			Pack       string `bson:"pack"`
+			Command    string `bson:"command"`
+		} `bson:"waiting"`
+	}
+
+	iter := states.Find(waiting).Iter()
+	for iter.Next(&s) {
+		// Only saving commands ever used waiting states
+		if !s.Waiting.ForCommand {
+			if err := states.RemoveId(s.ID); err != nil {
+				iter.Close()
+				return err
+			}
+			continue
+		}
+
+		set := bson.M{"flow": "neverforget", "step": 0, "data": bson.M{"pack": s.Waiting.Pack}}
+		if s.Waiting.Command != "" {
+			set["step"] = 1
+			set["data"] = bson.M{"pack": s.Waiting.Pack, "name": s.Waiting.Command}
+		}
+
+		err := states.UpdateId(s.ID, bson.M{"$set": set, "$unset": bson.M{"waiting": ""}})
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// migrateCommandNames normalizes the names of the commands. When several
+// commands normalize to the same name, the one already normalized or else
+// the most recent one takes it, and the others are logged and left as they
+// are, to be removed by their verbatim names
+func migrateCommandNames(d *mgo.Database, dryRun bool) error {
+	type key struct {
+		pack, name string
+		numParams  int
+	}
+
+	commands := d.C("commands")
+	var all []struct {
+		ID      bson.ObjectId `bson:"_id"`
+		Command `bson:",inline"`
+	}
+	if err := commands.Find(nil).Sort("-time").All(&all); err != nil {
+		return err
+	}
+
+	// Sorted by time, the first of each group is the most recent
+	groups := make(map[key][]int)
+	var keys []key
+	for i, c := range all {
+		k := key{c.Pack, util.NormalizeName(c.Name), c.Answer.NumParams}
+		if groups[k] == nil {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+
+	for _, k := range keys {
+		group := groups[k]
+		winner := group[0]
+		for _, i := range group {
+			if all[i].Name == k.name {
+				winner = i
+			}
+		}
+
+		for _, i := range group {
+			if i != winner {
+				log.Printf("Command '%s' collides with '%s', leaving it as is\n",
+					all[i].FullName(), all[winner].FullName())
+			}
+		}
+
+		c := all[winner]
+		if c.Name == k.name {
+			continue
+		}
+		if dryRun {
+			log.Printf("Would rename '%s' to '%s'\n", c.Name, k.name)
+			continue
+		}
+		if err := commands.UpdateId(c.ID, bson.M{"$set": bson.M{"name": k.name}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package monebot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/victormoneratto/monebot/expr"
+)
+
+// maxRenderDepth is how deep commands may reference other commands
+const maxRenderDepth = 5
+
+// Rendering an answer expands at most maxRenderExpansions placeholders, and
+// gives up once the text is longer than maxRenderLength, the longest
+// message telegram sends
+const (
+	maxRenderExpansions = 200
+	maxRenderLength     = 4096
+)
+
+var (
+	ErrRenderCycle      = errors.New("Command references itself")
+	ErrRenderDepth      = errors.New("Commands reference each other too deep")
+	ErrRenderExpansions = errors.New("Answer expands too many placeholders")
+	ErrRenderLength     = errors.New("Answer is too long")
+)
+
+// PlaceholderFunc returns what replaces a placeholder, given its argument
+// with the placeholders in it already expanded
+type PlaceholderFunc func(ctx context.Context, r *Renderer, arg string) (string, error)
+
+// Placeholders are keyed by the prefix before the colon, as in {cmd:hug}
+var Placeholders = map[string]PlaceholderFunc{}
+
+// RegisterPlaceholder makes the placeholder available to answers
+func RegisterPlaceholder(prefix string, f PlaceholderFunc) {
+	Placeholders[prefix] = f
+}
+
+func init() {
+	RegisterPlaceholder("cmd", renderCommand)
+	RegisterPlaceholder("calc", renderCalc)
+}
+
+// Renderer expands the placeholders of the texts of commands answered in a
+// chat. Within a placeholder, {1}, {2}... are the parameters of the command
+type Renderer struct {
+	Store Store
+	Chat  int64
+	Rand  *rand.Rand
+
+	// Repeat renders an answer already sent, e.g. on a reroll, so
+	// placeholders with side effects must not apply them again
+	Repeat bool
+
+	// Commands being rendered, innermost last
+	stack []renderFrame
+
+	// Placeholders expanded by the outermost Render so far
+	expansions int
+}
+
+type renderFrame struct {
+	command Command
+	params  []string
+}
+
+// NewRenderer returns a renderer of answers in the chat
+func NewRenderer(s Store, chat int64) *Renderer {
+	return &Renderer{Store: s, Chat: chat, Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Render expands the placeholders of the text of the command and formats
+// it with the parameters
+func (r *Renderer) Render(ctx context.Context, c Command, text string, params []string) (string, error) {
+	for _, f := range r.stack {
+		if f.command.FullName() == c.FullName() {
+			return "", ErrRenderCycle
+		}
+	}
+	if len(r.stack) == maxRenderDepth {
+		return "", ErrRenderDepth
+	}
+	if len(r.stack) == 0 {
+		r.expansions = 0
+	}
+
+	r.stack = append(r.stack, renderFrame{c, params})
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	text, err := r.expand(ctx, text, false)
+	if err != nil {
+		return "", err
+	}
+	if text = FormatParams(text, params); len(text) > maxRenderLength {
+		return "", ErrRenderLength
+	}
+	return text, nil
+}
+
+// Command returns the command being rendered
+func (r *Renderer) Command() Command {
+	return r.stack[len(r.stack)-1].command
+}
+
+// Params returns the parameters of the command being rendered
+func (r *Renderer) Params() []string {
+	return r.stack[len(r.stack)-1].params
+}
+
+// expand replaces the known placeholders of text, and the parameter
+// references if inArg. Placeholders with unknown prefixes are kept as is
+func (r *Renderer) expand(ctx context.Context, text string, inArg bool) (string, error) {
+	var b bytes.Buffer
+	for {
+		start := strings.IndexByte(text, '{')
+		if start == -1 {
+			b.WriteString(text)
+			return b.String(), nil
+		}
+		end := matchingBrace(text, start)
+		if end == -1 {
+			b.WriteString(text)
+			return b.String(), nil
+		}
+
+		b.WriteString(text[:start])
+		inner := text[start+1 : end]
+		text = text[end+1:]
+
+		value, ok, err := r.placeholder(ctx, inner, inArg)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			b.WriteString("{" + inner + "}")
+			continue
+		}
+
+		// Texts with parameters are formatted after expanding
+		if !inArg && len(r.Params()) > 0 {
+			value = strings.Replace(value, "%", "%%", -1)
+		}
+		b.WriteString(value)
+		if b.Len() > maxRenderLength {
+			return "", ErrRenderLength
+		}
+	}
+}
+
+// placeholder returns the value of the placeholder with the inner text, or
+// false if it isn't one
+func (r *Renderer) placeholder(ctx context.Context, inner string, inArg bool) (string, bool, error) {
+	if n, err := strconv.Atoi(inner); err == nil && inArg {
+		if params := r.Params(); n >= 1 && n <= len(params) {
+			return params[n-1], true, nil
+		}
+		return "", false, nil
+	}
+
+	colon := strings.IndexByte(inner, ':')
+	if colon == -1 {
+		return "", false, nil
+	}
+	f, ok := Placeholders[inner[:colon]]
+	if !ok {
+		return "", false, nil
+	}
+	if r.expansions++; r.expansions > maxRenderExpansions {
+		return "", true, ErrRenderExpansions
+	}
+
+	arg, err := r.expand(ctx, inner[colon+1:], true)
+	if err != nil {
+		return "", true, err
+	}
+	value, err := f(ctx, r, strings.TrimSpace(arg))
+	return value, true, err
+}
+
+// matchingBrace returns the index of the brace closing the one at start, or
+// -1 if it isn't closed
+func matchingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// renderCommand answers {cmd:[pack.]name [param, param...]} with the text of
+// the command. Names without a pack are in the pack of the command rendered
+func renderCommand(ctx context.Context, r *Renderer, arg string) (string, error) {
+	name, rest := arg, ""
+	if space := strings.IndexByte(arg, ' '); space != -1 {
+		name, rest = arg[:space], strings.TrimSpace(arg[space+1:])
+	}
+
+	pack := r.Command().Pack
+	if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+		pack, name = parts[0], parts[1]
+	}
+
+	var params []string
+	if rest != "" {
+		params = strings.Split(rest, ", ")
+	}
+
+	c, err := r.Store.FindCommand(ctx, pack, name, len(params))
+	if err == ErrNotFound {
+		return "", fmt.Errorf("Unknown command %s.%s with %d parameters", pack, name, len(params))
+	} else if err != nil {
+		return "", err
+	}
+
+	ans, _ := c.Answer.Texts().Pick(r.Rand, -1)
+	if ans.Text == "" {
+		return "", fmt.Errorf("Command %s has no text", c.FullName())
+	}
+	return r.Render(ctx, c, ans.Text, params)
+}
+
+// renderCalc answers {calc:expression} with the value of the expression, in
+// which $1, $2... are the parameters of the command
+func renderCalc(ctx context.Context, r *Renderer, arg string) (string, error) {
+	vars := make(map[string]interface{})
+	for i, param := range r.Params() {
+		vars["$"+strconv.Itoa(i+1)] = param
+	}
+
+	v, err := expr.Eval(arg, expr.Env{Vars: vars, Rand: r.Rand})
+	if err != nil {
+		return "", fmt.Errorf("%s in {calc:%s}", err, arg)
+	}
+	return expr.Format(v), nil
+}
@@ -0,0 +1,123 @@
+package monebot
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// commandStore finds commands in a map keyed by full name, ignoring
+// parameters
+type commandStore struct {
+	Store
+	commands map[string]Command
+}
+
+func (s commandStore) FindCommand(ctx context.Context, pack, name string, numParams int) (Command, error) {
+	if c, ok := s.commands[pack+"."+name]; ok {
+		return c, nil
+	}
+	return Command{}, ErrNotFound
+}
+
+func newCommandStore(commands ...Command) commandStore {
+	s := commandStore{commands: make(map[string]Command)}
+	for _, c := range commands {
+		s.commands[c.FullName()] = c
+	}
+	return s
+}
+
+func TestRenderCommand(t *testing.T) {
+	greeting := Command{Pack: "memes", Name: "greeting", Answer: NewTextAnswer("Hello, %s")}
+	hug := Command{Pack: "memes", Name: "hug", Answer: NewTextAnswer("{cmd:greeting {1}}! *hugs %s* {unknown}")}
+
+	r := NewRenderer(newCommandStore(greeting, hug), 1)
+	text, err := r.Render(context.Background(), hug, hug.Answer.Text, []string{"100%"})
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := "Hello, 100%! *hugs 100%* {unknown}"; text != want {
+		t.Errorf("Expected '%s', got '%s'", want, text)
+	}
+}
+
+func TestRenderCycle(t *testing.T) {
+	a := Command{Pack: "memes", Name: "a", Answer: NewTextAnswer("{cmd:b}")}
+	b := Command{Pack: "memes", Name: "b", Answer: NewTextAnswer("{cmd:memes.a}")}
+
+	r := NewRenderer(newCommandStore(a, b), 1)
+	if _, err := r.Render(context.Background(), a, a.Answer.Text, nil); err != ErrRenderCycle {
+		t.Error("Expected cycle error, got", err)
+	}
+}
+
+func TestRenderDepth(t *testing.T) {
+	var commands []Command
+	for _, name := range "abcdef" {
+		text := "{cmd:" + string(name+1) + "}"
+		commands = append(commands, Command{Name: string(name), Answer: NewTextAnswer(text)})
+	}
+	commands = append(commands, Command{Name: "g", Answer: NewTextAnswer("end")})
+
+	r := NewRenderer(newCommandStore(commands...), 1)
+	if _, err := r.Render(context.Background(), commands[0], commands[0].Answer.Text, nil); err != ErrRenderDepth {
+		t.Error("Expected depth error, got", err)
+	}
+}
+
+func TestRenderExpansions(t *testing.T) {
+	// Each level references the next ten times, 1110 expansions in all
+	var commands []Command
+	for _, name := range "abc" {
+		text := strings.Repeat("{cmd:"+string(name+1)+"}", 10)
+		commands = append(commands, Command{Name: string(name), Answer: NewTextAnswer(text)})
+	}
+	commands = append(commands, Command{Name: "d", Answer: NewTextAnswer("d")})
+
+	r := NewRenderer(newCommandStore(commands...), 1)
+	if _, err := r.Render(context.Background(), commands[0], commands[0].Answer.Text, nil); err != ErrRenderExpansions {
+		t.Error("Expected expansions error, got", err)
+	}
+}
+
+func TestRenderLength(t *testing.T) {
+	long := Command{Name: "long", Answer: NewTextAnswer(strings.Repeat("a", 100))}
+	longer := Command{Name: "longer", Answer: NewTextAnswer(strings.Repeat("{cmd:long}", 50))}
+
+	r := NewRenderer(newCommandStore(long, longer), 1)
+	if _, err := r.Render(context.Background(), longer, longer.Answer.Text, nil); err != ErrRenderLength {
+		t.Error("Expected length error, got", err)
+	}
+}
+
+func TestRenderCalcModulo(t *testing.T) {
+	s := newCommandStore(Command{Name: "mod", Answer: NewTextAnswer("{calc:10%3}")})
+	mod, err := s.FindCommand(context.Background(), "", "mod", 0)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if mod.Answer.Text != "{calc:10%3}" || mod.Answer.NumParams != 0 {
+		t.Fatalf("Expected the answer saved as is, got %+v", mod.Answer)
+	}
+
+	text, err := NewRenderer(s, 1).Render(context.Background(), mod, mod.Answer.Text, nil)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if text != "1" {
+		t.Errorf("Expected '1', got '%s'", text)
+	}
+}
+
+func TestRenderCalc(t *testing.T) {
+	split := Command{Name: "split", Answer: NewTextAnswer("%s / %s = {calc:round($1 / $2, 2)}")}
+
+	text, err := NewRenderer(newCommandStore(split), 1).Render(context.Background(), split, split.Answer.Text, []string{"120", "7"})
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := "120 / 7 = 17.14"; text != want {
+		t.Errorf("Expected '%s', got '%s'", want, text)
+	}
+}
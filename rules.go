@@ -0,0 +1,147 @@
+package monebot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule is a variant of a command's answer, used when all its conditions
+// hold. Unset conditions always hold
+type Rule struct {
+	Chat     int64          `bson:"chat,omitempty" json:"chat,omitempty"`
+	User     string         `bson:"user,omitempty" json:"user,omitempty"`
+	Weekdays []time.Weekday `bson:"weekdays,omitempty" json:"weekdays,omitempty"`
+
+	// From and To are a daily time window as "15:04", which wraps past
+	// midnight if From is after To
+	From string `bson:"from,omitempty" json:"from,omitempty"`
+	To   string `bson:"to,omitempty" json:"to,omitempty"`
+
+	Answer Answer `bson:"answer" json:"answer"`
+}
+
+// weekdays are the names of the days in rules, as in "mon-fri"
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseRule parses the conditions of a rule separated by commas, each being
+// "here" for the chat, or "chat <id>" for any chat as String writes it, a
+// @username, a weekday or a range of weekdays, or a time window, e.g.
+// "here,mon-fri,11:30-14:00"
+func ParseRule(conditions string, chat int64) (Rule, error) {
+	var r Rule
+	for _, cond := range strings.Split(strings.ToLower(conditions), ",") {
+		cond = strings.TrimSpace(cond)
+		from, to := cond, cond
+		if dash := strings.IndexByte(cond, '-'); dash != -1 {
+			from, to = cond[:dash], cond[dash+1:]
+		}
+
+		var id int64
+		switch {
+		case cond == "here":
+			r.Chat = chat
+		case strings.HasPrefix(cond, "chat "):
+			if _, err := fmt.Sscanf(cond, "chat %d", &id); err != nil || id == 0 {
+				return r, fmt.Errorf("unknown chat in '%s'", cond)
+			}
+			r.Chat = id
+		case strings.HasPrefix(cond, "@") && len(cond) > 1:
+			r.User = cond[1:]
+		case parseWeekday(from) != -1 && parseWeekday(to) != -1:
+			for d := parseWeekday(from); ; d = (d + 1) % 7 {
+				r.Weekdays = append(r.Weekdays, d)
+				if d == parseWeekday(to) {
+					break
+				}
+			}
+		case from != to && validClock(from) && validClock(to):
+			r.From, r.To = from, to
+		default:
+			return r, fmt.Errorf("unknown condition '%s'", cond)
+		}
+	}
+	return r, nil
+}
+
+// parseWeekday returns the weekday named by its first three letters or
+// more, or -1
+func parseWeekday(s string) time.Weekday {
+	if len(s) < 3 {
+		return -1
+	}
+	for i, day := range weekdays {
+		if strings.HasPrefix(s, day) && strings.HasPrefix(strings.ToLower(time.Weekday(i).String()), s) {
+			return time.Weekday(i)
+		}
+	}
+	return -1
+}
+
+func validClock(s string) bool {
+	_, err := time.Parse("15:04", s)
+	return err == nil && len(s) == 5
+}
+
+// Matches reports whether the rule holds for the user, in the chat, at t
+func (r Rule) Matches(chat int64, user string, t time.Time) bool {
+	if r.Chat != 0 && r.Chat != chat {
+		return false
+	}
+	if r.User != "" && !strings.EqualFold(r.User, user) {
+		return false
+	}
+
+	if len(r.Weekdays) > 0 {
+		found := false
+		for _, d := range r.Weekdays {
+			found = found || d == t.Weekday()
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.From != "" {
+		now := t.Format("15:04")
+		if r.From <= r.To {
+			return r.From <= now && now < r.To
+		}
+		return now >= r.From || now < r.To
+	}
+	return true
+}
+
+// String returns the conditions of the rule as parsed by ParseRule
+func (r Rule) String() string {
+	var conds []string
+	if r.Chat != 0 {
+		conds = append(conds, fmt.Sprintf("chat %d", r.Chat))
+	}
+	if r.User != "" {
+		conds = append(conds, "@"+r.User)
+	}
+	for _, d := range r.Weekdays {
+		conds = append(conds, weekdays[d])
+	}
+	if r.From != "" {
+		conds = append(conds, r.From+"-"+r.To)
+	}
+	if len(conds) == 0 {
+		return "always"
+	}
+	return strings.Join(conds, ", ")
+}
+
+// AnswerFor returns the answer of the first rule matching the user in the
+// chat at t, or the command's answer if none matches
+func (c Command) AnswerFor(chat int64, user string, t time.Time) Answer {
+	for _, r := range c.Rules {
+		if r.Matches(chat, user, t) {
+			ans := r.Answer
+			ans.NumParams = c.Answer.NumParams
+			return ans
+		}
+	}
+	return c.Answer
+}
@@ -0,0 +1,55 @@
+package monebot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("here, @Ana, fri-mon, 22:00-02:00", 42)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := "chat 42, @ana, fri, sat, sun, mon, 22:00-02:00"; r.String() != want {
+		t.Errorf("Expected '%s', got '%s'", want, r.String())
+	}
+
+	// String writes what ParseRule reads back, in any chat
+	if again, err := ParseRule(r.String(), 1); err != nil || again.String() != r.String() {
+		t.Errorf("Expected '%s' parsed back, got '%s' (%v)", r.String(), again.String(), err)
+	}
+
+	for _, conds := range []string{"", "fr", "someday", "25:00-26:00", "@", "10:00-10:00", "chat x", "chat 0"} {
+		if _, err := ParseRule(conds, 42); err == nil {
+			t.Errorf("Expected error parsing '%s'", conds)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	r, _ := ParseRule("friday,23:00-01:00", 42)
+
+	friday := time.Date(2026, 10, 23, 23, 30, 0, 0, time.UTC)
+	if !r.Matches(1, "ana", friday) {
+		t.Error("Expected match on friday at 23:30")
+	}
+	if r.Matches(1, "ana", friday.Add(-time.Hour)) {
+		t.Error("Expected no match on friday at 22:30")
+	}
+	if r.Matches(1, "ana", friday.Add(-24*time.Hour)) {
+		t.Error("Expected no match on thursday")
+	}
+}
+
+func TestAnswerFor(t *testing.T) {
+	r, _ := ParseRule("@ana", 42)
+	r.Answer = NewTextAnswer("hi Ana")
+	c := Command{Answer: NewTextAnswer("hi"), Rules: []Rule{r}}
+
+	if ans := c.AnswerFor(1, "Ana", time.Now()); ans.Text != "hi Ana" {
+		t.Error("Expected the rule's answer, got", ans.Text)
+	}
+	if ans := c.AnswerFor(1, "bob", time.Now()); ans.Text != "hi" {
+		t.Error("Expected the command's answer, got", ans.Text)
+	}
+}
@@ -0,0 +1,55 @@
+package monebot
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Store holds the persistent data operations used by the bot, implemented
+// by Database and by Cache
+type Store interface {
+	FindPack(ctx context.Context, chat int64) (string, error)
+	AssignPack(ctx context.Context, chat int64, pack string) error
+	FindCommand(ctx context.Context, pack, name string, numParams int) (Command, error)
+	UpsertCommand(ctx context.Context, c Command) error
+	RemoveCommand(ctx context.Context, pack, name string, numParams int) error
+	ListCommands(ctx context.Context, packs ...string) ([]Command, error)
+
+	FindState(ctx context.Context, chat int64, user int) (State, error)
+	UpsertState(ctx context.Context, s State) error
+	RemoveState(ctx context.Context, chat int64, user int) error
+	ExpireStates(ctx context.Context, before time.Time) ([]State, error)
+
+	FindSettings(ctx context.Context, chat int64) (Settings, error)
+	UpsertSettings(ctx context.Context, settings Settings) error
+
+	FindOffset(ctx context.Context) (int, error)
+	SaveOffset(ctx context.Context, updateID int) error
+	ClaimUpdate(ctx context.Context, updateID int, owner string, lease time.Duration) error
+	FinishUpdate(ctx context.Context, updateID int) error
+	ClaimScheduler(ctx context.Context, owner string, lease time.Duration) error
+
+	SaveReroll(ctx context.Context, r Reroll) error
+	ClaimReroll(ctx context.Context, chat int64, message, limit int) (Reroll, error)
+	SaveRerollText(ctx context.Context, chat int64, message int, text string) error
+
+	FindVariable(ctx context.Context, scope, name string) (Variable, error)
+	SetVariable(ctx context.Context, v Variable) error
+	IncrementCounter(ctx context.Context, scope, name string, delta int64) (int64, error)
+	RemoveVariable(ctx context.Context, scope, name string) error
+
+	AddReminder(ctx context.Context, r Reminder) (Reminder, error)
+	ListReminders(ctx context.Context, chat int64) ([]Reminder, error)
+	DueReminders(ctx context.Context, before time.Time) ([]Reminder, error)
+	ClaimReminder(ctx context.Context, id bson.ObjectId, now time.Time, lease time.Duration) (Reminder, error)
+	RemoveReminder(ctx context.Context, chat int64, id bson.ObjectId) error
+
+	AddSchedule(ctx context.Context, s Schedule) (Schedule, error)
+	ListSchedules(ctx context.Context, chat int64) ([]Schedule, error)
+	DueSchedules(ctx context.Context, before time.Time) ([]Schedule, error)
+	ClaimSchedule(ctx context.Context, id bson.ObjectId, from, now time.Time, lease time.Duration) (Schedule, error)
+	AdvanceSchedule(ctx context.Context, id bson.ObjectId, from, to time.Time) error
+	RemoveSchedule(ctx context.Context, chat int64, id bson.ObjectId) error
+}
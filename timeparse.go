@@ -0,0 +1,149 @@
+package monebot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHour is the time of day of dates given without one
+const defaultHour = 9
+
+// durationUnits are the units of durations, by their names
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// ParseWhen parses the time at the start of s, relative to now and in its
+// location, returning it and the rest of s. It understands durations, as in
+// "in 10m", "2h", "1h30m" or "in 2 hours", and dates with an optional time,
+// as in "tomorrow 9:00", "fri at 6pm", "2026-12-24 20:00" or just "18:30",
+// which is the next time the clock shows it
+func ParseWhen(s string, now time.Time) (time.Time, string, error) {
+	words := strings.Fields(s)
+	rest := func(n int) string {
+		return strings.Join(words[n:], " ")
+	}
+	if len(words) == 0 {
+		return now, "", fmt.Errorf("missing time")
+	}
+
+	// Durations
+	n := 0
+	if strings.ToLower(words[0]) == "in" {
+		n = 1
+	}
+	if d, used, ok := parseDuration(words[n:]); ok {
+		return now.Add(d), rest(n + used), nil
+	} else if n == 1 {
+		return now, "", fmt.Errorf("invalid duration '%s'", rest(1))
+	}
+
+	// Dates, then the time of day
+	day, hasDay := now, true
+	switch word := strings.ToLower(words[0]); {
+	case word == "today":
+	case word == "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	case parseWeekday(word) != -1:
+		days := (int(parseWeekday(word)) - int(now.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		day = now.AddDate(0, 0, days)
+	default:
+		date, err := time.ParseInLocation("2006-01-02", word, now.Location())
+		if err != nil {
+			hasDay = false
+			break
+		}
+		day = date
+	}
+	if hasDay {
+		n = 1
+	}
+
+	if len(words) > n && strings.ToLower(words[n]) == "at" {
+		n++
+	}
+	hour, min, ok := 0, 0, false
+	if len(words) > n {
+		if hour, min, ok = parseClock(words[n]); ok {
+			n++
+		}
+	}
+
+	switch {
+	case hasDay && !ok:
+		hour = defaultHour
+	case !hasDay && !ok:
+		return now, "", fmt.Errorf("invalid time '%s'", words[0])
+	}
+
+	// The wall clock time, even on days when clocks change
+	year, month, date := day.Date()
+	t := time.Date(year, month, date, hour, min, 0, 0, now.Location())
+	if !hasDay && !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	if !t.After(now) {
+		return now, "", fmt.Errorf("%s is in the past", t.Format("2006-01-02 15:04"))
+	}
+	return t, rest(n), nil
+}
+
+// parseDuration parses a duration at the start of words, as a single word
+// like 1h30m or a number followed by a unit word. It returns the duration
+// and the number of words used
+func parseDuration(words []string) (time.Duration, int, bool) {
+	if len(words) == 0 {
+		return 0, 0, false
+	}
+
+	if len(words) > 1 {
+		if n, err := strconv.Atoi(words[0]); err == nil && n > 0 {
+			if unit, ok := durationUnits[strings.ToLower(words[1])]; ok {
+				return time.Duration(n) * unit, 2, true
+			}
+		}
+	}
+
+	// Number and unit pairs, e.g. 1d12h
+	var total time.Duration
+	s := strings.ToLower(words[0])
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		j := i
+		for j < len(s) && (s[j] < '0' || s[j] > '9') {
+			j++
+		}
+
+		n, err := strconv.Atoi(s[:i])
+		unit, ok := durationUnits[s[i:j]]
+		if err != nil || !ok {
+			return 0, 0, false
+		}
+		total += time.Duration(n) * unit
+		s = s[j:]
+	}
+	return total, 1, total > 0
+}
+
+// parseClock parses a time of day as 15:04, 3:04pm, 3pm or 15h
+func parseClock(s string) (hour, min int, ok bool) {
+	s = strings.ToLower(s)
+	for _, layout := range []string{"15:04", "3:04pm", "3pm", "15h"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Hour(), t.Minute(), true
+		}
+	}
+	return 0, 0, false
+}
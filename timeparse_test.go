@@ -0,0 +1,79 @@
+package monebot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	// A Monday
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		in   string
+		want time.Time
+		rest string
+	}{
+		{"in 10m take out trash", now.Add(10 * time.Minute), "take out trash"},
+		{"2h standup", now.Add(2 * time.Hour), "standup"},
+		{"1h30m", now.Add(90 * time.Minute), ""},
+		{"in 2 hours call mom", now.Add(2 * time.Hour), "call mom"},
+		{"tomorrow 9:00 standup", time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC), "standup"},
+		{"tomorrow standup", time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC), "standup"},
+		{"today at 6pm dinner", time.Date(2026, 10, 19, 18, 0, 0, 0, time.UTC), "dinner"},
+		{"fri 18:30 drinks", time.Date(2026, 10, 23, 18, 30, 0, 0, time.UTC), "drinks"},
+		{"monday lunch", time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC), "lunch"},
+		{"18:30 drinks", time.Date(2026, 10, 19, 18, 30, 0, 0, time.UTC), "drinks"},
+		{"at 9:00 coffee", time.Date(2026, 10, 20, 9, 0, 0, 0, time.UTC), "coffee"},
+		{"2026-12-24 20:00 dinner", time.Date(2026, 12, 24, 20, 0, 0, 0, time.UTC), "dinner"},
+	}
+	for _, c := range cases {
+		got, rest, err := ParseWhen(c.in, now)
+		if err != nil {
+			t.Errorf("ParseWhen(%s): unexpected error %s", c.in, err)
+			continue
+		}
+		if !got.Equal(c.want) || rest != c.rest {
+			t.Errorf("ParseWhen(%s) = %s '%s', expected %s '%s'", c.in, got, rest, c.want, c.rest)
+		}
+	}
+}
+
+func TestParseWhenLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-3", -3*60*60)
+	now := time.Date(2026, 10, 19, 22, 0, 0, 0, loc)
+
+	got, _, err := ParseWhen("tomorrow 9:00", now)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestParseWhenDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("No time zone database:", err)
+	}
+	// Clocks go forward on the night of 2026-03-29
+	now := time.Date(2026, 3, 28, 20, 0, 0, 0, loc)
+
+	got, _, err := ParseWhen("tomorrow 9:00", now)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := time.Date(2026, 3, 29, 9, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestParseWhenErrors(t *testing.T) {
+	now := time.Date(2026, 10, 19, 14, 30, 0, 0, time.UTC)
+	for _, s := range []string{"", "soon", "in a while", "2020-01-01 9:00", "today 9:00", "25:00", "0m"} {
+		if _, _, err := ParseWhen(s, now); err == nil {
+			t.Errorf("ParseWhen(%s): expected error", s)
+		}
+	}
+}
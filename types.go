@@ -2,32 +2,42 @@ package monebot
 
 import (
 	"fmt"
+	"sync"
 	"time"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
+// State holds the progress of a user in a conversation flow within a chat
 type State struct {
-	Chat       int64        `bson:"chat"`
-	User       int          `bson:"user"`
-	Waiting    WaitingState `bson:"waiting,omitempty"`
-	LastUpdate time.Time    `bson:"lastUpdate"`
-}
-
-func NewWaitingState(chat int64, user int, w WaitingState) State {
-	return State{Chat: chat, User: user, Waiting: w, LastUpdate: time.Now()}
+	Chat       int64             `bson:"chat" json:"chat"`
+	User       int               `bson:"user" json:"user"`
+	Flow       string            `bson:"flow" json:"flow"`
+	Step       int               `bson:"step" json:"step"`
+	Data       map[string]string `bson:"data,omitempty" json:"data,omitempty"`
+	LastUpdate time.Time         `bson:"lastUpdate" json:"lastUpdate"`
 }
 
-type WaitingState struct {
-	ForCommand bool   `bson:"forCommand,omitempty"`
-	Pack       string `bson:"pack,omitempty"`
-	Command    string `bson:"command,omitempty"`
+// NewFlowState returns the state of a user starting the flow with data
+func NewFlowState(chat int64, user int, flow string, data map[string]string) State {
+	if data == nil {
+		data = make(map[string]string)
+	}
+	return State{Chat: chat, User: user, Flow: flow, Data: data, LastUpdate: time.Now()}
 }
 
-// Answer holds the possible messages the bot can send
+// Answer holds the possible messages the bot can send. Its own text or
+// sticker is the first choice, followed by the alternatives, all with the
+// same number of parameters
 type Answer struct {
-	Text      string `bson:"text,omitempty"`
-	NumParams int    `bson:"numParams"`
-	Parse     string `bson:"parseMode,omitempty"`
-	Sticker   string `bson:"sticker,omitempty"`
+	Text      string `bson:"text,omitempty" json:"text,omitempty"`
+	NumParams int    `bson:"numParams" json:"numParams"`
+	Parse     string `bson:"parseMode,omitempty" json:"parseMode,omitempty"`
+	Sticker   string `bson:"sticker,omitempty" json:"sticker,omitempty"`
+
+	// Weight is how likely this choice is relative to the others, 1 if unset
+	Weight       int      `bson:"weight,omitempty" json:"weight,omitempty"`
+	Alternatives []Answer `bson:"alternatives,omitempty" json:"alternatives,omitempty"`
 }
 
 const (
@@ -35,14 +45,27 @@ const (
 	ParseHTML     = "HTML"
 )
 
+// AnyParams is the number of parameters of aliases, which resolve for any
+// number of parameters. Looking up a command with it matches any number
+const AnyParams = -1
+
 // Command holds the data about for persistent commands
 type Command struct {
-	Pack       string    `bson:"pack"`
-	Name       string    `bson:"name"`
-	Answer     Answer    `bson:"answer"`
-	Time       time.Time `bson:"time"`
-	Creator    string    `bson:"creator,omitempty"`
-	NumChanged int       `bson:"numChanged,omitempty"`
+	Pack       string    `bson:"pack" json:"pack"`
+	Name       string    `bson:"name" json:"name"`
+	Answer     Answer    `bson:"answer" json:"answer"`
+	Time       time.Time `bson:"time" json:"time"`
+	Creator    string    `bson:"creator,omitempty" json:"creator,omitempty"`
+	NumChanged int       `bson:"numChanged,omitempty" json:"numChanged,omitempty"`
+
+	// Rules are variants of the answer, the first matching one is used
+	Rules []Rule `bson:"rules,omitempty" json:"rules,omitempty"`
+
+	// Alias is the command this one resolves to, instead of an answer
+	Alias *CommandRef `bson:"alias,omitempty" json:"alias,omitempty"`
+
+	// Via holds the aliases followed to find this command, if any
+	Via []Command `bson:"-" json:"via,omitempty"`
 }
 
 // FullName returns the a string of the form <pack>.<name>
@@ -50,8 +73,145 @@ func (c Command) FullName() string {
 	return fmt.Sprintf("%s.%s", c.Pack, c.Name)
 }
 
+// CommandRef names a command, which is looked up as usual, falling back to
+// the default pack
+type CommandRef struct {
+	Pack string `bson:"pack" json:"pack"`
+	Name string `bson:"name" json:"name"`
+}
+
+// NewAlias returns an alias to the target command
+func NewAlias(pack, name string, target CommandRef) Command {
+	return Command{Pack: pack, Name: name, Answer: Answer{NumParams: AnyParams}, Alias: &target, Time: time.Now()}
+}
+
+// FullName returns the a string of the form <pack>.<name>
+func (r CommandRef) FullName() string {
+	return fmt.Sprintf("%s.%s", r.Pack, r.Name)
+}
+
+// Reroll is a message answering a command with alternatives, whose text
+// can be replaced by another alternative a limited number of times
+type Reroll struct {
+	Chat    int64      `bson:"chat" json:"chat"`
+	Message int        `bson:"message" json:"message"`
+	Command CommandRef `bson:"command" json:"command"`
+	Params  []string   `bson:"params,omitempty" json:"params,omitempty"`
+
+	// User is who sent the command, rerolls pick among the answers of the
+	// rules matching them at Time, not whoever pressed the button
+	User string `bson:"user,omitempty" json:"user,omitempty"`
+
+	// Text is the alternative last sent, before formatting the params
+	Text  string    `bson:"text" json:"text"`
+	Count int       `bson:"count" json:"count"`
+	Time  time.Time `bson:"time" json:"time"`
+}
+
+// Variable is a value kept for answers of a chat or a pack, either text or
+// a counter
+type Variable struct {
+	Scope string `bson:"scope" json:"scope"`
+	Name  string `bson:"name" json:"name"`
+	Value string `bson:"value,omitempty" json:"value,omitempty"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// ChatScope is the scope of the variables of a chat
+func ChatScope(chat int64) string {
+	return fmt.Sprintf("chat:%d", chat)
+}
+
+// PackScope is the scope of the variables of a pack, shared by its chats
+func PackScope(pack string) string {
+	return "pack:" + pack
+}
+
+// Schedule posts a command in a chat at the times of a cron expression,
+// evaluated in the chat's time zone
+type Schedule struct {
+	ID      bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	Chat    int64         `bson:"chat" json:"chat"`
+	Cron    string        `bson:"cron" json:"cron"`
+	Command CommandRef    `bson:"command" json:"command"`
+
+	// Missed is what to do about runs missed while the bot was down
+	Missed  string    `bson:"missed" json:"missed"`
+	Next    time.Time `bson:"next" json:"next"`
+	Creator string    `bson:"creator,omitempty" json:"creator,omitempty"`
+
+	// Leased is until when an attempt to post the next run holds the
+	// schedule, and Attempts how many were made
+	Leased   time.Time `bson:"leased,omitempty" json:"leased,omitempty"`
+	Attempts int       `bson:"attempts,omitempty" json:"attempts,omitempty"`
+}
+
+// Policies for the runs of a schedule missed while the bot was down
+const (
+	MissedSkip = "skip"
+	MissedOnce = "once"
+)
+
 // Pack holds a name for the pack and all chats that use it by default
 type Pack struct {
-	Name  string  `bson:"name"`
-	Chats []int64 `bson:"chats"`
+	Name  string  `bson:"name" json:"name"`
+	Chats []int64 `bson:"chats,omitempty" json:"chats,omitempty"`
+}
+
+// Settings holds how the bot behaves in a chat
+type Settings struct {
+	Chat           int64  `bson:"chat" json:"chat"`
+	ForceReply     bool   `bson:"forceReply" json:"forceReply"`
+	ReplyToReplied bool   `bson:"replyToReplied" json:"replyToReplied"`
+	SilentUnknown  bool   `bson:"silentUnknown" json:"silentUnknown"`
+	ParseMode      string `bson:"parseMode" json:"parseMode"`
+	OnlyAddressed  bool   `bson:"onlyAddressed" json:"onlyAddressed"`
+
+	// Timezone is the IANA name of the chat's time zone, UTC if empty
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+}
+
+// locations caches the time zones by name, as loading one reads it from disk
+var locations = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// Location returns the chat's time zone, UTC if unset or unknown
+func (s Settings) Location() *time.Location {
+	locations.Lock()
+	defer locations.Unlock()
+
+	if loc, ok := locations.m[s.Timezone]; ok {
+		return loc
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	locations.m[s.Timezone] = loc
+	return loc
+}
+
+// Reminder is a message to post in a chat at a time, mentioning a user
+type Reminder struct {
+	ID   bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	Chat int64         `bson:"chat" json:"chat"`
+
+	// Mention is who to remind, a @username or a user ID
+	Mention string    `bson:"mention" json:"mention"`
+	Text    string    `bson:"text" json:"text"`
+	Due     time.Time `bson:"due" json:"due"`
+	Creator string    `bson:"creator,omitempty" json:"creator,omitempty"`
+
+	// Leased is until when a delivery attempt holds the reminder, and
+	// Attempts how many were made
+	Leased   time.Time `bson:"leased,omitempty" json:"leased,omitempty"`
+	Attempts int       `bson:"attempts,omitempty" json:"attempts,omitempty"`
+}
+
+// DefaultSettings returns the settings of a chat that never changed them
+func DefaultSettings(chat int64) Settings {
+	return Settings{Chat: chat, ForceReply: true, ReplyToReplied: true, SilentUnknown: true}
 }
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrStopped = errors.New("Stopped")
+
+// Backoff calls f until it succeeds, waiting exponentially longer between
+// attempts, from initial up to max. It gives up with ErrStopped as soon as
+// stop is closed
+func Backoff(initial, max time.Duration, stop <-chan struct{}, f func() error) error {
+	delay := initial
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		log.Printf("Failed: %s, retrying in %s\n", err, delay)
+		select {
+		case <-stop:
+			return ErrStopped
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > max {
+			delay = max
+		}
+	}
+}
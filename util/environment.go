@@ -12,3 +12,11 @@ func MustGetenv(key string) string {
 	}
 	return value
 }
+
+// GetenvDefault returns the environment variable, or value if it is empty
+func GetenvDefault(key, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return value
+}
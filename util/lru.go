@@ -0,0 +1,146 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a fixed size cache, safe for concurrent use, that evicts the least
+// recently used entry when full. Entries older than its TTL are expired but
+// kept until evicted, so they can still be read with GetStale
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[interface{}]*list.Element
+
+	// generation counts the calls to Remove and Purge, see AddSince
+	generation uint64
+}
+
+type lruEntry struct {
+	key   interface{}
+	value interface{}
+	added time.Time
+}
+
+// NewLRU returns an empty cache holding up to size entries for up to ttl
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[interface{}]*list.Element),
+	}
+}
+
+// Get returns the value cached for key, if any and not expired
+func (c *LRU) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*lruEntry)
+	if time.Since(entry.added) > c.ttl {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+// GetStale returns the value cached for key, if any, even if expired
+func (c *LRU) GetStale(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// Add caches value for key, evicting the least recently used entry if full
+func (c *LRU) Add(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, value)
+}
+
+func (c *LRU) add(key, value interface{}) {
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value, entry.added = value, time.Now()
+		c.ll.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value, time.Now()})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Generation returns a value that changes whenever entries are removed
+func (c *LRU) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// AddSince caches value for key unless entries were removed since the
+// generation, when value may have been read before what removed them.
+// It reports whether value was cached
+func (c *LRU) AddSince(generation uint64, key, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return false
+	}
+	c.add(key, value)
+	return true
+}
+
+// Remove the entry for key, if any
+func (c *LRU) Remove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// Purge removes every entry
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.ll.Init()
+	c.items = make(map[interface{}]*list.Element)
+}
+
+// Len returns the number of entries, including expired ones
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
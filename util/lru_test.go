@@ -0,0 +1,50 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Get("a")
+	c.Add("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Error("Expected a to be 1, got", v)
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := NewLRU(2, time.Nanosecond)
+	c.Add("a", 1)
+	time.Sleep(time.Millisecond)
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expected a to be expired")
+	}
+	if v, ok := c.GetStale("a"); !ok || v != 1 {
+		t.Error("Expected stale a to be 1, got", v)
+	}
+}
+
+func TestLRUAddSince(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	generation := c.Generation()
+	c.Remove("b")
+
+	if c.AddSince(generation, "a", 1) {
+		t.Error("Expected a not to be added after a removal")
+	}
+	if !c.AddSince(c.Generation(), "a", 2) {
+		t.Fatal("Expected a to be added")
+	}
+	if v, ok := c.Get("a"); !ok || v != 2 {
+		t.Error("Expected a to be 2, got", v)
+	}
+}
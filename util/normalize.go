@@ -0,0 +1,15 @@
+package util
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeName trims, case folds and composes the name to NFC, so that
+// names typed differently (e.g. accents as combining marks, or ß as ss)
+// match
+func NormalizeName(name string) string {
+	return norm.NFC.String(cases.Fold().String(strings.TrimSpace(name)))
+}
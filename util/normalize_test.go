@@ -0,0 +1,26 @@
+package util
+
+import "testing"
+
+func TestNormalizeName(t *testing.T) {
+	cases := map[string]string{
+		"hug":          "hug",
+		" Hug ":        "hug",
+		"ABRAÇO":       "abraço",
+		"abraço":      "abraço",
+		"CAFÉ":        "café",
+		"ñö":         "ñö",
+		"x́":           "x́",
+		"́":            "́",
+		"N\u0300":      "\u01f9",
+		"\u1100\u1161": "\uac00",
+		"Stra\u00dfe":  "strasse",
+		"\u01c5":       "\u01c6",
+		"":             "",
+	}
+	for in, want := range cases {
+		if got := NormalizeName(in); got != want {
+			t.Errorf("NormalizeName(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
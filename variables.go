@@ -0,0 +1,104 @@
+package monebot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/victormoneratto/monebot/util"
+)
+
+var (
+	ErrMissingVariable = errors.New("Missing variable name")
+	ErrForeignPack     = errors.New("Only chats using the pack can change its variables")
+)
+
+func init() {
+	RegisterPlaceholder("counter", renderCounter)
+	RegisterPlaceholder("var", renderVariable)
+}
+
+// VariableScope returns the scope and normalized name of the variable given
+// as "[pack.]name" in the chat. Variables with a pack are shared by all chats
+func VariableScope(chat int64, name string) (scope, varName string) {
+	if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+		return PackScope(parts[0]), util.NormalizeName(parts[1])
+	}
+	return ChatScope(chat), util.NormalizeName(name)
+}
+
+// CanChangeScope reports whether the chat may change the variables of the
+// scope: its own, or those of the pack it uses
+func CanChangeScope(ctx context.Context, s Store, chat int64, scope string) (bool, error) {
+	if !strings.HasPrefix(scope, PackScope("")) {
+		return scope == ChatScope(chat), nil
+	}
+
+	pack, err := s.FindPack(ctx, chat)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil && PackScope(pack) == scope, err
+}
+
+// String returns the text of the variable, or its count if it has none
+func (v Variable) String() string {
+	if v.Value != "" {
+		return v.Value
+	}
+	return strconv.FormatInt(v.Count, 10)
+}
+
+// renderCounter answers {counter:[pack.]name} with the count, incrementing
+// it first if followed by ++, or decrementing it if followed by --. Counters
+// don't change on repeated renders, and those of a pack only change in its
+// chats or in its own commands
+func renderCounter(ctx context.Context, r *Renderer, arg string) (string, error) {
+	var delta int64
+	switch {
+	case strings.HasSuffix(arg, "++"):
+		delta = 1
+	case strings.HasSuffix(arg, "--"):
+		delta = -1
+	}
+
+	scope, name := VariableScope(r.Chat, strings.TrimRight(arg, "+-"))
+	if name == "" {
+		return "", ErrMissingVariable
+	}
+
+	if delta != 0 && scope != PackScope(r.Command().Pack) {
+		ok, err := CanChangeScope(ctx, r.Store, r.Chat, scope)
+		if err != nil {
+			return "", err
+		} else if !ok {
+			return "", ErrForeignPack
+		}
+	}
+
+	if delta == 0 || r.Repeat {
+		v, err := r.Store.FindVariable(ctx, scope, name)
+		if err != nil && err != ErrNotFound {
+			return "", err
+		}
+		return strconv.FormatInt(v.Count, 10), nil
+	}
+
+	count, err := r.Store.IncrementCounter(ctx, scope, name, delta)
+	return strconv.FormatInt(count, 10), err
+}
+
+// renderVariable answers {var:[pack.]name} with the variable, empty if unset
+func renderVariable(ctx context.Context, r *Renderer, arg string) (string, error) {
+	scope, name := VariableScope(r.Chat, arg)
+	if name == "" {
+		return "", ErrMissingVariable
+	}
+
+	v, err := r.Store.FindVariable(ctx, scope, name)
+	if err == ErrNotFound {
+		return "", nil
+	}
+	return v.String(), err
+}
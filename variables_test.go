@@ -0,0 +1,89 @@
+package monebot
+
+import (
+	"context"
+	"testing"
+)
+
+// variableStore keeps variables in a map keyed by scope and name
+type variableStore struct {
+	Store
+	vars map[string]Variable
+	pack string
+}
+
+func (s variableStore) FindPack(ctx context.Context, chat int64) (string, error) {
+	if s.pack == "" {
+		return "", ErrNotFound
+	}
+	return s.pack, nil
+}
+
+func (s variableStore) FindVariable(ctx context.Context, scope, name string) (Variable, error) {
+	if v, ok := s.vars[scope+" "+name]; ok {
+		return v, nil
+	}
+	return Variable{}, ErrNotFound
+}
+
+func (s variableStore) IncrementCounter(ctx context.Context, scope, name string, delta int64) (int64, error) {
+	v := s.vars[scope+" "+name]
+	v.Count += delta
+	s.vars[scope+" "+name] = v
+	return v.Count, nil
+}
+
+func TestVariableScope(t *testing.T) {
+	if scope, name := VariableScope(42, " Coffee"); scope != "chat:42" || name != "coffee" {
+		t.Errorf("Expected chat:42 coffee, got %s %s", scope, name)
+	}
+	if scope, name := VariableScope(42, "memes.coffee"); scope != "pack:memes" || name != "coffee" {
+		t.Errorf("Expected pack:memes coffee, got %s %s", scope, name)
+	}
+}
+
+func TestRenderCounter(t *testing.T) {
+	s := variableStore{vars: map[string]Variable{"chat:1 name": {Value: "Ana"}}}
+	c := Command{Name: "coffee", Answer: NewTextAnswer("{var:name}: {counter:coffee++}, {counter:coffee++}, {counter:coffee}")}
+
+	text, err := NewRenderer(s, 1).Render(context.Background(), c, c.Answer.Text, nil)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if want := "Ana: 1, 2, 2"; text != want {
+		t.Errorf("Expected '%s', got '%s'", want, text)
+	}
+}
+
+func TestRenderCounterRepeat(t *testing.T) {
+	s := variableStore{vars: map[string]Variable{"chat:1 coffee": {Count: 3}}}
+	c := Command{Name: "coffee", Answer: NewTextAnswer("{counter:coffee++}")}
+
+	r := NewRenderer(s, 1)
+	r.Repeat = true
+	text, err := r.Render(context.Background(), c, c.Answer.Text, nil)
+	if err != nil {
+		t.Fatal("Expected no error, got", err)
+	}
+	if text != "3" || s.vars["chat:1 coffee"].Count != 3 {
+		t.Errorf("Expected the count to stay 3, got '%s' and %d", text, s.vars["chat:1 coffee"].Count)
+	}
+}
+
+func TestRenderCounterForeignPack(t *testing.T) {
+	s := variableStore{vars: map[string]Variable{}, pack: "memes"}
+	c := Command{Name: "coffee", Answer: NewTextAnswer("{counter:other.coffee++}")}
+
+	if _, err := NewRenderer(s, 1).Render(context.Background(), c, c.Answer.Text, nil); err != ErrForeignPack {
+		t.Errorf("Expected ErrForeignPack, got %v", err)
+	}
+
+	// The pack's own commands and the chats using it may change its counters
+	own := Command{Pack: "other", Name: "coffee", Answer: NewTextAnswer("{counter:other.coffee++}")}
+	used := Command{Name: "coffee", Answer: NewTextAnswer("{counter:memes.coffee++}")}
+	for _, c := range []Command{own, used} {
+		if _, err := NewRenderer(s, 1).Render(context.Background(), c, c.Answer.Text, nil); err != nil {
+			t.Errorf("Expected no error for %s, got %s", c.Answer.Text, err)
+		}
+	}
+}